@@ -0,0 +1,114 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/writeas/go-writeas/v2"
+)
+
+// apiClient makes requests to write.as API endpoints that are not supported by
+// the go-writeas client, such as updating collections.
+type apiClient struct {
+	url   string
+	token string
+	http  *http.Client
+	debug *log.Logger
+}
+
+// apiError is an error response from the write.as API.
+type apiError struct {
+	Code int    `json:"code"`
+	Msg  string `json:"error_msg"`
+}
+
+func (err apiError) Error() string {
+	if err.Msg == "" {
+		return fmt.Sprintf("write.as API returned status %d", err.Code)
+	}
+	return fmt.Sprintf("write.as API returned status %d: %s", err.Code, err.Msg)
+}
+
+// newAPIClient returns a client that uses the same URL, token, and Tor proxy as
+// a go-writeas client created with cfg.
+func newAPIClient(cfg writeas.Config, debug *log.Logger) *apiClient {
+	transport := &http.Transport{}
+	if cfg.TorPort > 0 {
+		transport.Proxy = http.ProxyURL(&url.URL{
+			Scheme: "socks5",
+			Host:   "127.0.0.1:" + strconv.Itoa(cfg.TorPort),
+		})
+	}
+	return &apiClient{
+		url:   strings.TrimSuffix(cfg.URL, "/"),
+		token: cfg.Token,
+		debug: debug,
+		http: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: transport,
+		},
+	}
+}
+
+// do makes a request to the endpoint at path with body encoded as JSON, and
+// decodes the data from the response envelope into v.
+// If body or v are nil, they are not sent or decoded respectively.
+func (c *apiClient) do(method, path string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.url+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "blogsync")
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Token "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := apiError{Code: resp.StatusCode}
+		// The error message is optional, if it can't be decoded just report the
+		// status code.
+		err = json.NewDecoder(resp.Body).Decode(&apiErr)
+		if err != nil {
+			c.debug.Printf("error decoding error response from %s %s: %v", method, path, err)
+		}
+		apiErr.Code = resp.StatusCode
+		return apiErr
+	}
+	if v == nil {
+		return nil
+	}
+	env := struct {
+		Data interface{} `json:"data"`
+	}{Data: v}
+	return json.NewDecoder(resp.Body).Decode(&env)
+}
@@ -5,27 +5,775 @@
 package main
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/writeas/go-writeas/v2"
 	"mellium.im/cli"
 )
 
-func collectionsCmd(client *writeas.Client, logger, debug *log.Logger) *cli.Command {
+// assetsDir is the directory that style sheets for collections are loaded
+// from if no other file is configured.
+const assetsDir = "assets"
+
+// Visibility values understood by write.as, mapped to the values sent to the
+// API.
+var collVisibility = map[string]int{
+	"unlisted": 0,
+	"public":   1,
+	"private":  2,
+}
+
+// Formats understood by write.as.
+var collFormats = map[string]bool{
+	"blog":     true,
+	"novel":    true,
+	"notebook": true,
+}
+
+// CollectionConfig holds the settings for a collection that are pushed to the
+// server by the "collections sync" command.
+// Any fields that are empty are left unchanged on the server.
+type CollectionConfig struct {
+	Title       string `toml:"Title"`
+	Description string `toml:"Description"`
+	StyleSheet  string `toml:"StyleSheet"`
+	Script      string `toml:"Script"`
+
+	// StyleSheetFile is the path to a CSS file to use as the StyleSheet.
+	// If neither is set, "assets/<alias>.css" is used if it exists.
+	StyleSheetFile string `toml:"StyleSheetFile"`
+
+	// Visibility is one of "public", "unlisted", or "private".
+	Visibility string `toml:"Visibility"`
+
+	// Format is one of "blog", "novel", or "notebook".
+	Format string `toml:"Format"`
+}
+
+// remoteCollection is a collection as returned by the write.as API.
+// Fields that are nil were not returned by the server.
+type remoteCollection struct {
+	Alias       string  `json:"alias"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	StyleSheet  *string `json:"style_sheet"`
+	Script      *string `json:"script"`
+	Format      *string `json:"format"`
+	Visibility  *int    `json:"visibility"`
+	Public      *bool   `json:"public"`
+	Private     *bool   `json:"private"`
+	URL         string  `json:"url"`
+	TotalPosts  int     `json:"total_posts"`
+}
+
+// collectionPosts is a collection along with its posts as returned by the
+// write.as API.
+type collectionPosts struct {
+	remoteCollection
+	Posts []struct {
+		ID             string    `json:"id"`
+		Slug           string    `json:"slug"`
+		Title          string    `json:"title"`
+		Created        time.Time `json:"created"`
+		PinnedPosition *int      `json:"pinned_position"`
+	} `json:"posts"`
+}
+
+// collectionUpdate is the body of a request to update a collection.
+type collectionUpdate struct {
+	Alias       string  `json:"alias,omitempty"`
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	StyleSheet  *string `json:"style_sheet,omitempty"`
+	Script      *string `json:"script,omitempty"`
+	Format      *string `json:"format,omitempty"`
+	Visibility  *int    `json:"visibility,omitempty"`
+}
+
+func collectionsCmd(siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) *cli.Command {
+	listCmd := collectionsListCmd(clientConfig, logger, debug)
+	return &cli.Command{
+		Usage:       "collections [command]",
+		Description: `List or manage collections owned by the authenticated user.`,
+		Commands: []*cli.Command{
+			collectionsCreateCmd(clientConfig, logger, debug),
+			collectionsDeleteCmd(clientConfig, logger, debug),
+			listCmd,
+			collectionsPullCSSCmd(siteConfig, clientConfig, torPort, logger, debug),
+			collectionsShowCmd(clientConfig, logger, debug),
+			collectionsSyncCmd(siteConfig, clientConfig, torPort, logger, debug),
+			collectionsUpdateCmd(clientConfig, logger, debug),
+		},
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(args) > 0 {
+				cmd.Help()
+				return fmt.Errorf("unknown collections command %q", args[0])
+			}
+			return listCmd.Run(listCmd)
+		},
+	}
+}
+
+// collectionSummary is the information about a collection that is shown by the
+// list and show commands.
+type collectionSummary struct {
+	Alias       string `json:"alias"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url"`
+	Visibility  string `json:"visibility"`
+	Posts       int    `json:"posts"`
+}
+
+// postSummary is the information about a post that is shown by the show
+// command.
+type postSummary struct {
+	ID     string    `json:"id"`
+	Slug   string    `json:"slug"`
+	Title  string    `json:"title"`
+	Date   time.Time `json:"date"`
+	Pinned *int      `json:"pinned,omitempty"`
+}
+
+// summary converts the collection returned by the server into the format
+// shown to users.
+func (coll remoteCollection) summary() collectionSummary {
+	s := collectionSummary{
+		Alias:      coll.Alias,
+		URL:        coll.URL,
+		Posts:      coll.TotalPosts,
+		Visibility: "unknown",
+	}
+	if coll.Title != nil {
+		s.Title = *coll.Title
+	}
+	if coll.Description != nil {
+		s.Description = *coll.Description
+	}
+	switch {
+	case coll.Visibility != nil:
+		for name, v := range collVisibility {
+			if v == *coll.Visibility {
+				s.Visibility = name
+			}
+		}
+	case coll.Private != nil && *coll.Private:
+		s.Visibility = "private"
+	case coll.Public != nil && *coll.Public:
+		s.Visibility = "public"
+	}
+	return s
+}
+
+// printJSON writes v to stdout as indented JSON.
+func printJSON(v interface{}) error {
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "  ")
+	return e.Encode(v)
+}
+
+func collectionsListCmd(clientConfig writeas.Config, logger, debug *log.Logger) *cli.Command {
+	asJSON := false
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.BoolVar(&asJSON, "json", asJSON, "Print the collections as JSON")
+
 	return &cli.Command{
-		Usage:       "collections",
+		Usage:       "list [--json]",
+		Flags:       flags,
 		Description: `List collections owned by the authenticated user.`,
 		Run: func(cmd *cli.Command, args ...string) error {
-			colls, err := client.GetUserCollections()
+			var colls []remoteCollection
+			err := newAPIClient(clientConfig, debug).do(http.MethodGet, "/me/collections", nil, &colls)
+			if err != nil {
+				return err
+			}
+			summaries := make([]collectionSummary, 0, len(colls))
+			for _, coll := range colls {
+				summaries = append(summaries, coll.summary())
+			}
+			if asJSON {
+				return printJSON(summaries)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ALIAS\tTITLE\tVISIBILITY\tPOSTS\tURL")
+			for _, s := range summaries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", s.Alias, s.Title, s.Visibility, s.Posts, s.URL)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func collectionsShowCmd(clientConfig writeas.Config, logger, debug *log.Logger) *cli.Command {
+	asJSON := false
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	flags.BoolVar(&asJSON, "json", asJSON, "Print the collection and its posts as JSON")
+
+	return &cli.Command{
+		Usage:       "show [--json] alias",
+		Flags:       flags,
+		Description: `Show a collection and list its posts, with any pinned posts first.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(flags.Args()) != 1 {
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+			alias := flags.Args()[0]
+
+			coll, err := fetchCollectionPosts(newAPIClient(clientConfig, debug), alias)
+			if err != nil {
+				return err
+			}
+
+			posts := make([]postSummary, 0, len(coll.Posts))
+			for _, p := range coll.Posts {
+				posts = append(posts, postSummary{
+					ID:     p.ID,
+					Slug:   p.Slug,
+					Title:  p.Title,
+					Date:   p.Created,
+					Pinned: p.PinnedPosition,
+				})
+			}
+			sort.SliceStable(posts, func(i, j int) bool {
+				pi, pj := posts[i].Pinned, posts[j].Pinned
+				switch {
+				case pi != nil && pj != nil:
+					return *pi < *pj
+				case pi != nil || pj != nil:
+					return pi != nil
+				}
+				return posts[i].Date.After(posts[j].Date)
+			})
+
+			summary := coll.summary()
+			if summary.Posts == 0 {
+				summary.Posts = len(posts)
+			}
+			if asJSON {
+				return printJSON(struct {
+					collectionSummary
+					Posts []postSummary `json:"posts"`
+				}{
+					collectionSummary: summary,
+					Posts:             posts,
+				})
+			}
+
+			fmt.Printf("%s (%s)\n", summary.Title, summary.Alias)
+			if summary.Description != "" {
+				fmt.Println(summary.Description)
+			}
+			fmt.Printf("Visibility: %s\nURL: %s\nPosts: %d\n\n", summary.Visibility, summary.URL, summary.Posts)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "PIN\tDATE\tSLUG\tTITLE")
+			for _, p := range posts {
+				pin := ""
+				if p.Pinned != nil {
+					pin = strconv.Itoa(*p.Pinned)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", pin, p.Date.Format("2006-01-02"), p.Slug, p.Title)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// fetchCollectionPosts fetches the collection with the given alias and every
+// one of its posts, requesting one page of posts at a time until an empty page
+// is returned.
+func fetchCollectionPosts(api *apiClient, alias string) (collectionPosts, error) {
+	var coll collectionPosts
+	seen := make(map[string]bool)
+	path := "/collections/" + url.PathEscape(alias) + "/posts"
+	for page := 1; ; page++ {
+		var resp collectionPosts
+		err := api.do(http.MethodGet, path+"?page="+strconv.Itoa(page), nil, &resp)
+		if err != nil {
+			return coll, err
+		}
+		if page == 1 {
+			coll.remoteCollection = resp.remoteCollection
+		}
+		var added bool
+		for _, post := range resp.Posts {
+			// Stop if the server ignores the page and keeps returning the same
+			// posts.
+			if seen[post.ID] {
+				continue
+			}
+			seen[post.ID] = true
+			added = true
+			coll.Posts = append(coll.Posts, post)
+		}
+		if !added {
+			break
+		}
+	}
+	if coll.Alias == "" {
+		coll.Alias = alias
+	}
+	return coll, nil
+}
+
+func collectionsCreateCmd(clientConfig writeas.Config, logger, debug *log.Logger) *cli.Command {
+	var title, description string
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.StringVar(&title, "title", title, "The title of the collection (defaults to the alias)")
+	flags.StringVar(&description, "description", description, "A description of the collection")
+
+	return &cli.Command{
+		Usage:       "create [options] alias",
+		Flags:       flags,
+		Description: `Create a new collection.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(flags.Args()) != 1 {
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+			alias := flags.Args()[0]
+			client := writeas.NewClientWith(clientConfig)
+			coll, err := client.CreateCollection(&writeas.CollectionParams{
+				Alias:       alias,
+				Title:       orDef(title, alias),
+				Description: description,
+			})
+			if err != nil {
+				return err
+			}
+			logger.Printf("created collection %s", coll.Alias)
+			return nil
+		},
+	}
+}
+
+func collectionsUpdateCmd(clientConfig writeas.Config, logger, debug *log.Logger) *cli.Command {
+	var coll CollectionConfig
+	flags := flag.NewFlagSet("update", flag.ContinueOnError)
+	flags.StringVar(&coll.Title, "title", coll.Title, "The title of the collection")
+	flags.StringVar(&coll.Description, "description", coll.Description, "A description of the collection")
+	flags.StringVar(&coll.Visibility, "visibility", coll.Visibility, `Who can see the collection, one of "public", "unlisted", or "private"`)
+	flags.StringVar(&coll.Format, "format", coll.Format, `The format of the collection, one of "blog", "novel", or "notebook"`)
+	flags.StringVar(&coll.StyleSheetFile, "css", coll.StyleSheetFile, "A file containing custom CSS for the collection")
+
+	return &cli.Command{
+		Usage: "update [options] alias",
+		Flags: flags,
+		Description: `Update the settings of a collection.
+
+Only the settings that are given are changed.
+To keep settings in the config file instead, see the sync command.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(flags.Args()) != 1 {
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+			alias := flags.Args()[0]
+			if coll.StyleSheetFile != "" {
+				var err error
+				coll, err = coll.loadStyleSheet(alias)
+				if err != nil {
+					return err
+				}
+			}
+			// Make sure the collection exists so that we don't create a new one by
+			// accident.
+			api := newAPIClient(clientConfig, debug)
+			err := api.do(http.MethodGet, "/collections/"+url.PathEscape(alias), nil, nil)
+			if err != nil {
+				return fmt.Errorf("error fetching collection %s: %w", alias, err)
+			}
+			return pushCollections(api, map[string]CollectionConfig{alias: coll}, nil, false, logger, debug)
+		},
+	}
+}
+
+func collectionsDeleteCmd(clientConfig writeas.Config, logger, debug *log.Logger) *cli.Command {
+	yes := false
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	flags.BoolVar(&yes, "y", yes, "Do not ask for confirmation before deleting")
+
+	return &cli.Command{
+		Usage:       "delete [-y] alias",
+		Flags:       flags,
+		Description: `Permanently delete a collection and all of its posts.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(flags.Args()) != 1 {
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+			alias := flags.Args()[0]
+			if !yes {
+				fmt.Printf("Permanently delete collection %s and all of its posts? [y/N] ", alias)
+				answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+				if err != nil && err != io.EOF {
+					return err
+				}
+				if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+					return fmt.Errorf("not deleting collection %s", alias)
+				}
+			}
+			err := newAPIClient(clientConfig, debug).do(http.MethodDelete, "/collections/"+url.PathEscape(alias), nil, nil)
 			if err != nil {
 				return err
 			}
+			logger.Printf("deleted collection %s", alias)
+			return nil
+		},
+	}
+}
+
+func collectionsSyncCmd(siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) *cli.Command {
+	var (
+		dryRun = false
+		target = ""
+	)
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	flags.BoolVar(&dryRun, "dry-run", dryRun, "Show which collections would be changed without changing them")
+	flags.StringVar(&target, "target", target, "A comma separated list of targets from the config file to sync (defaults to all targets)")
+
+	return &cli.Command{
+		Usage: "sync [options]",
+		Flags: flags,
+		Description: fmt.Sprintf(`Push collection settings from the config file to the server.
+
+Each table in the Collections section of the config file is named after the
+alias of a collection and may contain a Title, Description, StyleSheet, Script,
+Visibility ("public", "unlisted", or "private"), and Format ("blog", "novel", or
+"notebook").
+If no StyleSheet is set, it is loaded from the StyleSheetFile or from
+%s if it exists.
+Settings that are not present in the config are left unchanged and collections
+that do not exist are created.
+When run with --dry-run, settings that are out of date, including style sheets,
+are reported without being changed.`, filepath.Join(assetsDir, "<alias>.css")),
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(siteConfig.Collections) == 0 {
+				logger.Printf("no collections found in the config, nothing to sync")
+				return nil
+			}
+			if len(siteConfig.Targets) == 0 {
+				if target != "" {
+					return fmt.Errorf("--target was specified but no targets are configured")
+				}
+				return syncCollections(newAPIClient(clientConfig, debug), siteConfig.Collections, nil, dryRun, logger, debug)
+			}
 
-			for _, coll := range *colls {
-				fmt.Printf("%+v\n", coll)
+			targets, err := selectTargets(siteConfig.Targets, target)
+			if err != nil {
+				return err
+			}
+			var failed []string
+			for _, t := range targets {
+				logger.Printf("syncing collections to target %s (%s)…", t.Name, t.URL)
+				cfg, err := t.config(torPort, debug)
+				if err == nil {
+					err = syncCollections(newAPIClient(cfg, debug), siteConfig.Collections, t.Collections, dryRun, logger, debug)
+				}
+				if err != nil {
+					logger.Printf("target %s: %v", t.Name, err)
+					failed = append(failed, t.Name)
+				}
+			}
+			if len(failed) > 0 {
+				return fmt.Errorf("syncing collections failed for targets: %s", strings.Join(failed, ", "))
 			}
 			return nil
 		},
 	}
 }
+
+func collectionsPullCSSCmd(siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) *cli.Command {
+	target := ""
+	flags := flag.NewFlagSet("pull-css", flag.ContinueOnError)
+	flags.StringVar(&target, "target", target, "The target from the config file to fetch style sheets from")
+
+	return &cli.Command{
+		Usage: "pull-css [options] [aliases...]",
+		Flags: flags,
+		Description: fmt.Sprintf(`Fetch the custom CSS of collections from the server.
+
+The style sheet of each collection is written to the StyleSheetFile set for the
+collection in the config file, or to %s.
+If no aliases are given, the collections in the Collections section of the
+config file and the default collection are fetched.`, filepath.Join(assetsDir, "<alias>.css")),
+		Run: func(cmd *cli.Command, args ...string) error {
+			cfg := clientConfig
+			var rename map[string]string
+			if target != "" {
+				targets, err := selectTargets(siteConfig.Targets, target)
+				if err != nil {
+					return err
+				}
+				if len(targets) != 1 {
+					return fmt.Errorf("style sheets can only be fetched from one target at a time")
+				}
+				cfg, err = targets[0].config(torPort, debug)
+				if err != nil {
+					return err
+				}
+				rename = targets[0].Collections
+			}
+			api := newAPIClient(cfg, debug)
+
+			aliases := flags.Args()
+			if len(aliases) == 0 {
+				for alias := range siteConfig.Collections {
+					aliases = append(aliases, alias)
+				}
+				if _, ok := siteConfig.Collections[siteConfig.Collection]; !ok && siteConfig.Collection != "" {
+					aliases = append(aliases, siteConfig.Collection)
+				}
+				sort.Strings(aliases)
+			}
+			if len(aliases) == 0 {
+				return fmt.Errorf("no collections to fetch style sheets for")
+			}
+
+			var failed bool
+			for _, alias := range aliases {
+				remoteAlias := alias
+				if r, ok := rename[alias]; ok {
+					remoteAlias = r
+				}
+				var remote remoteCollection
+				err := api.do(http.MethodGet, "/collections/"+url.PathEscape(remoteAlias), nil, &remote)
+				if err != nil {
+					logger.Printf("error fetching collection %s: %v", remoteAlias, err)
+					failed = true
+					continue
+				}
+				if remote.StyleSheet == nil {
+					logger.Printf("server did not return a style sheet for collection %s", remoteAlias)
+					failed = true
+					continue
+				}
+
+				fname, _ := siteConfig.Collections[alias].styleSheetFile(alias)
+				debug.Printf("writing style sheet for collection %s to %s…", remoteAlias, fname)
+				err = os.MkdirAll(filepath.Dir(fname), 0755)
+				if err == nil {
+					err = ioutil.WriteFile(fname, []byte(*remote.StyleSheet), 0644)
+				}
+				if err != nil {
+					logger.Printf("error writing style sheet for collection %s: %v", remoteAlias, err)
+					failed = true
+				}
+			}
+			if failed {
+				return fmt.Errorf("some style sheets could not be fetched")
+			}
+			return nil
+		},
+	}
+}
+
+// syncStyleSheets uploads the style sheet of each collection in aliases that
+// has one configured if it differs from the one on the server.
+// If dryRun is set, out of date style sheets are reported but not uploaded.
+func syncStyleSheets(api *apiClient, siteConfig Config, aliases []string, rename map[string]string, dryRun bool, logger, debug *log.Logger) {
+	for _, alias := range aliases {
+		coll, err := siteConfig.Collections[alias].loadStyleSheet(alias)
+		if err != nil {
+			logger.Print(err)
+			continue
+		}
+		if coll.StyleSheet == "" {
+			continue
+		}
+		if r, ok := rename[alias]; ok {
+			alias = r
+		}
+		path := "/collections/" + url.PathEscape(alias)
+
+		var remote remoteCollection
+		err = api.do(http.MethodGet, path, nil, &remote)
+		if err != nil {
+			logger.Printf("error fetching style sheet for collection %s: %v", alias, err)
+			continue
+		}
+		if remote.StyleSheet != nil && *remote.StyleSheet == coll.StyleSheet {
+			debug.Printf("style sheet for collection %s is up to date", alias)
+			continue
+		}
+		if dryRun {
+			logger.Printf("style sheet for collection %s is out of date", alias)
+			continue
+		}
+		debug.Printf("uploading style sheet for collection %s…", alias)
+		err = api.do(http.MethodPost, path, collectionUpdate{StyleSheet: &coll.StyleSheet}, nil)
+		if err != nil {
+			logger.Printf("error uploading style sheet for collection %s: %v", alias, err)
+		}
+	}
+}
+
+// syncCollections updates the settings of each collection on the server to
+// match the config, creating any collections that do not exist.
+// Aliases in rename are replaced by the aliases they map to before talking to
+// the server.
+func syncCollections(api *apiClient, colls map[string]CollectionConfig, rename map[string]string, dryRun bool, logger, debug *log.Logger) error {
+	loaded := make(map[string]CollectionConfig, len(colls))
+	for alias, coll := range colls {
+		coll, err := coll.loadStyleSheet(alias)
+		if err != nil {
+			return err
+		}
+		loaded[alias] = coll
+	}
+	return pushCollections(api, loaded, rename, dryRun, logger, debug)
+}
+
+// pushCollections updates the settings of each collection on the server to
+// match colls, creating any collections that do not exist.
+// Unlike syncCollections, style sheets are not loaded from files.
+func pushCollections(api *apiClient, colls map[string]CollectionConfig, rename map[string]string, dryRun bool, logger, debug *log.Logger) error {
+	aliases := make([]string, 0, len(colls))
+	for alias, coll := range colls {
+		if _, ok := collVisibility[coll.Visibility]; coll.Visibility != "" && !ok {
+			return fmt.Errorf("invalid visibility %q for collection %s", coll.Visibility, alias)
+		}
+		if coll.Format != "" && !collFormats[coll.Format] {
+			return fmt.Errorf("invalid format %q for collection %s", coll.Format, alias)
+		}
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	var failed bool
+	for _, alias := range aliases {
+		coll := colls[alias]
+		if remote, ok := rename[alias]; ok {
+			alias = remote
+		}
+		path := "/collections/" + url.PathEscape(alias)
+
+		var remote remoteCollection
+		err := api.do(http.MethodGet, path, nil, &remote)
+		var apiErr apiError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound:
+			logger.Printf("creating collection %s…", alias)
+			if !dryRun {
+				err = api.do(http.MethodPost, "/collections", collectionUpdate{
+					Alias: alias,
+					Title: strPtr(orDef(coll.Title, alias)),
+				}, nil)
+				if err != nil {
+					logger.Printf("error creating collection %s: %v", alias, err)
+					failed = true
+					continue
+				}
+			}
+		case err != nil:
+			logger.Printf("error fetching collection %s: %v", alias, err)
+			failed = true
+			continue
+		}
+
+		update, changed := coll.diff(remote)
+		if len(changed) == 0 {
+			debug.Printf("collection %s is up to date", alias)
+			continue
+		}
+		if dryRun {
+			logger.Printf("%s of collection %s out of date", strings.Join(changed, ", "), alias)
+			continue
+		}
+		logger.Printf("updating %s of collection %s…", strings.Join(changed, ", "), alias)
+		err = api.do(http.MethodPost, path, update, nil)
+		if err != nil {
+			logger.Printf("error updating collection %s: %v", alias, err)
+			failed = true
+		}
+	}
+	if failed {
+		return fmt.Errorf("some collections could not be synced")
+	}
+	return nil
+}
+
+// diff returns the update needed to make the remote collection match the
+// config along with the names of the settings that changed.
+// If the server did not return a setting it is assumed to be out of date.
+func (coll CollectionConfig) diff(remote remoteCollection) (update collectionUpdate, changed []string) {
+	check := func(name, want string, have *string) *string {
+		if want == "" || (have != nil && *have == want) {
+			return nil
+		}
+		changed = append(changed, name)
+		return strPtr(want)
+	}
+	update.Title = check("title", coll.Title, remote.Title)
+	update.Description = check("description", coll.Description, remote.Description)
+	update.StyleSheet = check("style sheet", coll.StyleSheet, remote.StyleSheet)
+	update.Script = check("script", coll.Script, remote.Script)
+	update.Format = check("format", coll.Format, remote.Format)
+
+	if coll.Visibility != "" {
+		want := collVisibility[coll.Visibility]
+		var upToDate bool
+		switch {
+		case remote.Visibility != nil:
+			upToDate = *remote.Visibility == want
+		case remote.Public != nil:
+			// Older servers only report whether the collection is public.
+			upToDate = *remote.Public == (coll.Visibility == "public")
+		}
+		if !upToDate {
+			changed = append(changed, "visibility")
+			update.Visibility = &want
+		}
+	}
+	return update, changed
+}
+
+// styleSheetFile returns the path of the CSS file for the collection, and
+// whether it was explicitly configured.
+func (coll CollectionConfig) styleSheetFile(alias string) (string, bool) {
+	if coll.StyleSheetFile != "" {
+		return coll.StyleSheetFile, true
+	}
+	return filepath.Join(assetsDir, alias+".css"), false
+}
+
+// loadStyleSheet sets StyleSheet to the contents of the collection's CSS file
+// if it is not already set.
+// It is only an error for the file not to exist if it was configured with
+// StyleSheetFile.
+func (coll CollectionConfig) loadStyleSheet(alias string) (CollectionConfig, error) {
+	if coll.StyleSheet != "" {
+		return coll, nil
+	}
+	fname, configured := coll.styleSheetFile(alias)
+	css, err := ioutil.ReadFile(fname)
+	switch {
+	case os.IsNotExist(err) && !configured:
+		return coll, nil
+	case err != nil:
+		return coll, fmt.Errorf("error loading style sheet for collection %s: %w", alias, err)
+	}
+	coll.StyleSheet = string(css)
+	return coll, nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
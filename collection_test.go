@@ -0,0 +1,174 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/writeas/go-writeas/v2"
+
+	"mellium.im/blogsync/internal/writeastest"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+var diffTestCases = [...]struct {
+	coll    CollectionConfig
+	remote  remoteCollection
+	update  collectionUpdate
+	changed []string
+}{
+	0: {},
+	1: {
+		coll:    CollectionConfig{Title: "Blog", Description: "Posts"},
+		remote:  remoteCollection{Title: strPtr("Blog"), Description: strPtr("Old")},
+		update:  collectionUpdate{Description: strPtr("Posts")},
+		changed: []string{"description"},
+	},
+	2: {
+		// Settings the server didn't report are assumed to be out of date.
+		coll:    CollectionConfig{StyleSheet: "body{}", Script: "x()", Format: "novel"},
+		update:  collectionUpdate{StyleSheet: strPtr("body{}"), Script: strPtr("x()"), Format: strPtr("novel")},
+		changed: []string{"style sheet", "script", "format"},
+	},
+	3: {
+		coll:   CollectionConfig{Visibility: "private"},
+		remote: remoteCollection{Visibility: intPtr(2)},
+	},
+	4: {
+		coll:    CollectionConfig{Visibility: "unlisted"},
+		remote:  remoteCollection{Visibility: intPtr(1)},
+		update:  collectionUpdate{Visibility: intPtr(0)},
+		changed: []string{"visibility"},
+	},
+	5: {
+		coll:   CollectionConfig{Visibility: "public"},
+		remote: remoteCollection{Public: boolPtr(true)},
+	},
+	6: {
+		coll:   CollectionConfig{Visibility: "unlisted"},
+		remote: remoteCollection{Public: boolPtr(false)},
+	},
+	7: {
+		coll:    CollectionConfig{Visibility: "unlisted"},
+		remote:  remoteCollection{Public: boolPtr(true)},
+		update:  collectionUpdate{Visibility: intPtr(0)},
+		changed: []string{"visibility"},
+	},
+	8: {
+		coll:    CollectionConfig{Visibility: "public"},
+		update:  collectionUpdate{Visibility: intPtr(1)},
+		changed: []string{"visibility"},
+	},
+}
+
+func TestDiff(t *testing.T) {
+	for i, tc := range diffTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			update, changed := tc.coll.diff(tc.remote)
+			if !reflect.DeepEqual(changed, tc.changed) {
+				t.Errorf("wrong changes: want=%q, got=%q", tc.changed, changed)
+			}
+			// Compare the JSON that would be sent since the update is all pointers.
+			want, err := json.Marshal(tc.update)
+			if err != nil {
+				t.Fatalf("error encoding expected update: %v", err)
+			}
+			got, err := json.Marshal(update)
+			if err != nil {
+				t.Fatalf("error encoding update: %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("wrong update: want=%s, got=%s", want, got)
+			}
+		})
+	}
+}
+
+func TestPushCollectionsDryRun(t *testing.T) {
+	srv := writeastest.NewServer()
+	defer srv.Close()
+	debug := log.New(ioutil.Discard, "", 0)
+	api := newAPIClient(writeas.Config{URL: srv.APIURL(), Token: srv.AddUser("me", "pass")}, debug)
+	colls := map[string]CollectionConfig{
+		"blog": {Title: "Blog", StyleSheet: "body{color:red}"},
+	}
+
+	// Create the collection so that only the style sheet is out of date.
+	err := pushCollections(api, map[string]CollectionConfig{"blog": {Title: "Blog"}}, nil, false, debug, debug)
+	if err != nil {
+		t.Fatalf("error creating collection: %v", err)
+	}
+
+	var logs bytes.Buffer
+	err = pushCollections(api, colls, nil, true, log.New(&logs, "", 0), debug)
+	if err != nil {
+		t.Fatalf("error during dry run: %v", err)
+	}
+	if want := "style sheet of collection blog out of date"; !strings.Contains(logs.String(), want) {
+		t.Errorf("expected dry run to report %q, got %q", want, logs.String())
+	}
+	if css := srv.Collections()[0].StyleSheet; css != "" {
+		t.Errorf("dry run changed the style sheet to %q", css)
+	}
+
+	err = pushCollections(api, colls, nil, false, debug, debug)
+	if err != nil {
+		t.Fatalf("error syncing collections: %v", err)
+	}
+	if css := srv.Collections()[0].StyleSheet; css != "body{color:red}" {
+		t.Errorf("wrong style sheet: want=%q, got=%q", "body{color:red}", css)
+	}
+}
+
+func TestFetchCollectionPosts(t *testing.T) {
+	srv := writeastest.NewServer()
+	defer srv.Close()
+	api := newAPIClient(writeas.Config{URL: srv.APIURL(), Token: srv.AddUser("me", "pass")}, log.New(ioutil.Discard, "", 0))
+
+	err := api.do(http.MethodPost, "/collections", collectionUpdate{Alias: "blog"}, nil)
+	if err != nil {
+		t.Fatalf("error creating collection: %v", err)
+	}
+	const total = 2*writeastest.PostsPerPage + 5
+	for i := 0; i < total; i++ {
+		err = api.do(http.MethodPost, "/collections/blog/posts", map[string]string{
+			"title": "Post " + strconv.Itoa(i),
+			"body":  "Content",
+		}, nil)
+		if err != nil {
+			t.Fatalf("error creating post %d: %v", i, err)
+		}
+	}
+
+	coll, err := fetchCollectionPosts(api, "blog")
+	if err != nil {
+		t.Fatalf("error fetching posts: %v", err)
+	}
+	if coll.Alias != "blog" {
+		t.Errorf("wrong alias: want=%q, got=%q", "blog", coll.Alias)
+	}
+	seen := make(map[string]bool)
+	for _, post := range coll.Posts {
+		seen[post.ID] = true
+	}
+	if len(coll.Posts) != total || len(seen) != total {
+		t.Errorf("wrong number of posts: want=%d, got=%d (%d unique)", total, len(coll.Posts), len(seen))
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	envEnvironment = "HUGO_ENVIRONMENT"
+	defEnvironment = "production"
+	configDir      = "config"
+	defaultDir     = "_default"
+)
+
+// cfgNames is the list of config files that are searched for in the site root,
+// in the same order that Hugo looks for them.
+var cfgNames = []string{
+	"hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json",
+	"config.toml", "config.yaml", "config.yml", "config.json",
+}
+
+var errNoConfig = errors.New("no config file found")
+
+// loadConfig finds and loads the site config the same way that Hugo does.
+//
+// If files is not empty it is a comma separated list of config files to load,
+// otherwise the first file from cfgNames that exists is loaded.
+// Then any files in the "config/_default" directory and the
+// "config/<environment>" directory are merged on top of it.
+// If nothing could be loaded, an error wrapping errNoConfig is returned.
+func loadConfig(files, environment string, logger, debug *log.Logger) (Config, error) {
+	siteConfig := Config{}
+	merged := make(map[string]interface{})
+
+	var loaded []string
+	if files != "" {
+		for _, fname := range strings.Split(files, ",") {
+			fname = strings.TrimSpace(fname)
+			siteConfig.sources = append(siteConfig.sources, fname)
+			err := mergeConfigFile(merged, nil, fname)
+			if err != nil {
+				return siteConfig, err
+			}
+			loaded = append(loaded, fname)
+		}
+	} else {
+		siteConfig.sources = append(siteConfig.sources, cfgNames...)
+		for _, fname := range cfgNames {
+			err := mergeConfigFile(merged, nil, fname)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return siteConfig, err
+			}
+			loaded = append(loaded, fname)
+			break
+		}
+	}
+
+	for _, dir := range []string{defaultDir, environment} {
+		if dir == "" {
+			continue
+		}
+		siteConfig.sources = append(siteConfig.sources, filepath.Join(configDir, dir))
+		dirFiles, err := mergeConfigDir(merged, filepath.Join(configDir, dir))
+		if err != nil {
+			return siteConfig, err
+		}
+		loaded = append(loaded, dirFiles...)
+	}
+
+	if len(loaded) == 0 {
+		return siteConfig, fmt.Errorf("%w: looked for %s and the %s directory", errNoConfig, strings.Join(cfgNames, ", "), filepath.Join(configDir, defaultDir))
+	}
+	debug.Printf("loaded config from %v", loaded)
+
+	// Round trip each key of the merged config through TOML to decode it into
+	// the Config struct so that values end up with the same types as if a single
+	// TOML file had been decoded.
+	// The keys are matched against the struct fields case insensitively which
+	// matches the behavior of Hugo.
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := decodeConfigKey(&siteConfig, key, merged[key])
+		if err != nil {
+			// Hugo configs may contain keys that we use with a different type (eg.
+			// the deprecated [author] table), so don't fail over them.
+			logger.Printf("ignoring invalid value for %s in config: %v", key, err)
+		}
+	}
+
+	var err error
+	siteConfig.location, err = time.LoadLocation(siteConfig.TimeZone)
+	if err != nil {
+		return siteConfig, fmt.Errorf("error loading time zone %q: %w", siteConfig.TimeZone, err)
+	}
+
+	return siteConfig, nil
+}
+
+// decodeConfigKey decodes val into the field of siteConfig matching key.
+func decodeConfigKey(siteConfig *Config, key string, val interface{}) error {
+	var buf bytes.Buffer
+	err := toml.NewEncoder(&buf).Encode(map[string]interface{}{key: dropNil(val)})
+	if err != nil {
+		return err
+	}
+	_, err = toml.Decode(buf.String(), siteConfig)
+	return err
+}
+
+// dropNil returns val with any nil values removed from the tables it contains,
+// since they can't be represented in TOML.
+func dropNil(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if child != nil {
+				m[key] = dropNil(child)
+			}
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, child := range v {
+			if child != nil {
+				s = append(s, dropNil(child))
+			}
+		}
+		return s
+	}
+	return val
+}
+
+// mergeConfigDir merges all of the config files in dir into dst.
+// Files named "hugo" or "config" are merged into the root of the config, other
+// files are merged into the key with the same name as the file, for example
+// "params.toml" is merged into the "params" table and "menus.en.toml" is merged
+// into the "menus" table of the "en" language.
+func mergeConfigDir(dst map[string]interface{}, dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the root config files are merged first so that the other files
+	// can override their tables.
+	sort.SliceStable(infos, func(i, j int) bool {
+		return isRootConfig(infos[i].Name()) && !isRootConfig(infos[j].Name())
+	})
+
+	var loaded []string
+	for _, info := range infos {
+		name := info.Name()
+		ext := filepath.Ext(name)
+		if info.IsDir() || !isConfigExt(ext) {
+			continue
+		}
+
+		var path []string
+		if !isRootConfig(name) {
+			path = strings.Split(strings.TrimSuffix(name, ext), ".")
+			if len(path) == 2 {
+				path = []string{"languages", path[1], path[0]}
+			}
+		}
+		fname := filepath.Join(dir, name)
+		err = mergeConfigFile(dst, path, fname)
+		if err != nil {
+			return loaded, err
+		}
+		loaded = append(loaded, fname)
+	}
+	return loaded, nil
+}
+
+// mergeConfigFile decodes the TOML, YAML, or JSON file fname and merges it into
+// the table in dst found by following path.
+func mergeConfigFile(dst map[string]interface{}, path []string, fname string) error {
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	src := make(map[string]interface{})
+	switch ext := filepath.Ext(fname); ext {
+	case ".toml":
+		err = toml.Unmarshal(b, &src)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &src)
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&src)
+		if err == nil {
+			src = jsonNumbers(src).(map[string]interface{})
+		}
+	default:
+		return fmt.Errorf("unknown config file format %q for %s", ext, fname)
+	}
+	if err != nil {
+		return fmt.Errorf("error loading %s: %w", fname, err)
+	}
+
+	for _, key := range path {
+		key = findKey(dst, key)
+		child, ok := dst[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			dst[key] = child
+		}
+		dst = child
+	}
+	mergeMaps(dst, src)
+	return nil
+}
+
+// jsonNumbers replaces any json.Number in val with an int64 if it is an integer
+// or a float64 otherwise, the same types used when decoding TOML.
+func jsonNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = jsonNumbers(child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = jsonNumbers(child)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return val
+}
+
+// mergeMaps recursively merges src into dst.
+// Keys are compared case insensitively, and values other than tables in src
+// replace the values in dst.
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		dstKey := findKey(dst, k)
+		srcMap, srcOK := v.(map[string]interface{})
+		dstMap, dstOK := dst[dstKey].(map[string]interface{})
+		if srcOK && dstOK {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[dstKey] = v
+	}
+}
+
+// findKey returns the key in m that matches key case insensitively, or key if
+// there is no such key.
+func findKey(m map[string]interface{}, key string) string {
+	if _, ok := m[key]; ok {
+		return key
+	}
+	for k := range m {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+func isRootConfig(name string) bool {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	return base == "hugo" || base == "config"
+}
+
+func isConfigExt(ext string) bool {
+	switch ext {
+	case ".toml", ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chdirTemp creates a temporary directory containing files, changes into it,
+// and returns a function that changes back and removes it.
+func chdirTemp(t *testing.T, files map[string]string) func() {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "blogsync")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	for name, content := range files {
+		fname := filepath.Join(dir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err != nil {
+			t.Fatalf("error creating dir for %s: %v", name, err)
+		}
+		err = ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("error getting working directory: %v", err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatalf("error changing to temp dir: %v", err)
+	}
+	return func() {
+		err := os.Chdir(wd)
+		if err != nil {
+			t.Errorf("error changing back to %s: %v", wd, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMergeConfigDir(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"config/_default/params.toml":   "author = \"me\"\ncount = 1\n",
+		"config/_default/config.toml":   "title = \"Root\"\n[params]\nauthor = \"root\"\ncolor = \"blue\"\n",
+		"config/_default/menus.fr.yaml": "main:\n  - name: Accueil\n",
+		"config/_default/notes.txt":     "ignored",
+		"config/_default/sub/hugo.toml": "title = \"Ignored\"\n",
+	})()
+
+	merged := make(map[string]interface{})
+	loaded, err := mergeConfigDir(merged, filepath.Join("config", "_default"))
+	if err != nil {
+		t.Fatalf("error merging config dir: %v", err)
+	}
+	wantLoaded := []string{
+		filepath.Join("config", "_default", "config.toml"),
+		filepath.Join("config", "_default", "menus.fr.yaml"),
+		filepath.Join("config", "_default", "params.toml"),
+	}
+	if !reflect.DeepEqual(loaded, wantLoaded) {
+		t.Errorf("wrong files loaded: want=%q, got=%q", wantLoaded, loaded)
+	}
+	want := map[string]interface{}{
+		"title": "Root",
+		"params": map[string]interface{}{
+			"author": "me",
+			"color":  "blue",
+			"count":  int64(1),
+		},
+		"languages": map[string]interface{}{
+			"fr": map[string]interface{}{
+				"menus": map[string]interface{}{
+					"main": []interface{}{map[string]interface{}{"name": "Accueil"}},
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("wrong merged config: want=%#v, got=%#v", want, merged)
+	}
+
+	loaded, err = mergeConfigDir(merged, filepath.Join("config", "missing"))
+	if err != nil || loaded != nil {
+		t.Errorf("expected missing dir to be skipped, got files %q and error %v", loaded, err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"config.json": `{"Title": "JSON", "Params": {"count": 2, "ratio": 0.5}, "Collection": "blog"}`,
+		"config/_default/params.toml": `published = 2020-01-02T03:04:05Z
+`,
+		"config/production/config.yaml": "baseURL: https://example.net/\nauthor:\n  name: Me\n",
+	})()
+
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	debug := log.New(ioutil.Discard, "", 0)
+	siteConfig, err := loadConfig("", "production", logger, debug)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if siteConfig.Title != "JSON" || siteConfig.Collection != "blog" || siteConfig.BaseURL != "https://example.net/" {
+		t.Errorf("wrong config values: %+v", siteConfig)
+	}
+	wantParams := map[string]interface{}{
+		"count":     int64(2),
+		"ratio":     0.5,
+		"published": time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(siteConfig.Params, wantParams) {
+		t.Errorf("wrong params: want=%#v, got=%#v", wantParams, siteConfig.Params)
+	}
+	// The deprecated [author] table doesn't match our list of authors.
+	if !strings.Contains(logs.String(), "ignoring invalid value for author") {
+		t.Errorf("expected invalid author to be logged, got %q", logs.String())
+	}
+}
+
+func TestLoadConfigLogsAllErrors(t *testing.T) {
+	defer chdirTemp(t, map[string]string{
+		"config.toml": "title = 1\nauthor = \"me\"\ncollection = \"blog\"\n",
+	})()
+
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	siteConfig, err := loadConfig("", "", logger, log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if siteConfig.Collection != "blog" {
+		t.Errorf("wrong collection: want=%q, got=%q", "blog", siteConfig.Collection)
+	}
+	for _, key := range []string{"title", "author"} {
+		if !strings.Contains(logs.String(), "ignoring invalid value for "+key) {
+			t.Errorf("expected invalid %s to be logged, got %q", key, logs.String())
+		}
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	defer chdirTemp(t, nil)()
+
+	discard := log.New(ioutil.Discard, "", 0)
+	_, err := loadConfig("", "production", discard, discard)
+	if !errors.Is(err, errNoConfig) {
+		t.Errorf("wrong error: want=%v, got=%v", errNoConfig, err)
+	}
+	_, err = loadConfig("missing.toml", "production", discard, discard)
+	if err == nil || errors.Is(err, errNoConfig) {
+		t.Errorf("expected error loading an explicit missing config, got %v", err)
+	}
+}
@@ -13,19 +13,23 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"mellium.im/blogsync/internal/blog"
 	"mellium.im/cli"
 )
 
-func convertCmd(logger, debug *log.Logger) *cli.Command {
+func convertCmd(siteConfig Config, logger, debug *log.Logger) *cli.Command {
 	var (
 		dryRun  = false
+		offsets = false
 		content = "content/"
 	)
 	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
 	flags.BoolVar(&dryRun, "dry-run", dryRun, "Perform a trial run with no changes made")
+	flags.BoolVar(&offsets, "offsets", offsets, "Rewrite TOML dates that do not have a UTC offset")
 	flags.StringVar(&content, "content", content, "A directory containing pages and posts")
 
 	return &cli.Command{
@@ -37,14 +41,28 @@ Convert searches the content directory for posts and performs the following
 transformations on any posts it finds:
 
 	- Convert YAML frontmatter beginning with --- to TOML
-	- Convert "date" and "lastmod" fields to TOML date types
+	- Convert "date", "lastmod", and "publishDate" fields to TOML date types
+	- If --offsets is set, add explicit UTC offsets to TOML dates that do not
+	  have one
 	- Ensure the body has a single leading and trailing \n and trim other leading
 	  and trailing whitespace
 
+Dates without a UTC offset are assumed to be in the time zone set by timeZone in
+the pages frontmatter or the site config, or in UTC if neither is set.
+Unless --offsets is set they are written back without an offset.
+
 This command does not attempt to preserve files if it fails. Be sure to make a
 backup or commit all files to source control before converting them.`,
 		Run: func(cmd *cli.Command, args ...string) error {
-			return blog.WalkPages(content, func(path string, info os.FileInfo, err error) error {
+			ignore, err := blog.LoadIgnore(content, siteConfig.IgnoreFiles)
+			if err != nil {
+				return fmt.Errorf("error loading ignore rules: %w", err)
+			}
+
+			// Walk all pages, including section pages and headless bundles that
+			// won't be published since they may still be used by Hugo.
+			return blog.Walk(content, ignore, func(page blog.Page, err error) error {
+				path := page.Path
 				fd, err := os.OpenFile(path, os.O_RDWR, 0666)
 				if err != nil {
 					logger.Printf("error opening %s, skipping: %v", path, err)
@@ -69,22 +87,28 @@ backup or commit all files to source control before converting them.`,
 					madeChanges = true
 					debug.Printf("converting non-TOML frontmatter in %s…", path)
 				}
-				const (
-					dateKey   = "date"
-					updateKey = "lastmod"
-				)
-				if date, ok := meta[dateKey]; ok {
-					if _, ok := date.(string); ok {
-						debug.Printf("converting string date in %s…", path)
-						meta[dateKey] = meta.GetTime(dateKey)
-						madeChanges = true
-					}
+				loc, err := meta.Location(siteConfig.location)
+				if err != nil {
+					logger.Printf("invalid time zone in %s, using %s instead: %v", path, loc, err)
 				}
-				if date, ok := meta[updateKey]; ok {
-					if _, ok := date.(string); ok {
-						debug.Printf("converting string lastmod in %s…", path)
-						meta[updateKey] = meta.GetTime(updateKey)
+				for _, key := range []string{"date", "lastmod", "publishDate"} {
+					switch date := meta[key].(type) {
+					case string:
+						debug.Printf("converting string %s in %s…", key, path)
 						madeChanges = true
+						// Dates without an offset are kept that way unless we were asked
+						// to add one.
+						if local, err := blog.ParseLocalTime(date); err == nil && !offsets {
+							meta[key] = local
+							continue
+						}
+						meta[key] = meta.GetTimeIn(key, loc)
+					case blog.LocalTime:
+						if offsets {
+							debug.Printf("adding offset to %s in %s…", key, path)
+							meta[key] = meta.GetTimeIn(key, loc)
+							madeChanges = true
+						}
 					}
 				}
 
@@ -125,8 +149,7 @@ backup or commit all files to source control before converting them.`,
 				if err != nil {
 					logger.Printf("could not write header start to %s: %v", path, err)
 				}
-				e := toml.NewEncoder(fd)
-				err = e.Encode(meta)
+				err = encodeMeta(fd, meta)
 				if err != nil {
 					logger.Printf("error encoding TOML in %s: %v", path, err)
 				}
@@ -150,3 +173,28 @@ backup or commit all files to source control before converting them.`,
 		},
 	}
 }
+
+// encodeMeta writes meta to w as TOML.
+// The TOML encoder writes every date in UTC, so dates without an offset are
+// replaced by placeholders while encoding and then written as they were
+// originally.
+func encodeMeta(w io.Writer, meta blog.Metadata) error {
+	encMeta := make(blog.Metadata, len(meta))
+	var replacements []string
+	for key, val := range meta {
+		if local, ok := val.(blog.LocalTime); ok {
+			placeholder := "blogsync-local-time-" + strconv.Itoa(len(replacements)/2)
+			replacements = append(replacements, strconv.Quote(placeholder), local.Raw)
+			val = placeholder
+		}
+		encMeta[key] = val
+	}
+
+	var buf strings.Builder
+	err := toml.NewEncoder(&buf).Encode(encMeta)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strings.NewReplacer(replacements...).Replace(buf.String()))
+	return err
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var convertTestCases = [...]struct {
+	in      string
+	offsets bool
+	out     string
+}{
+	0: {
+		// Trimming the body rewrites the file, but dates are left alone.
+		in:  "+++\ndate = 2020-01-01T10:00:00\n+++\n\n\nBody\n\n",
+		out: "+++\ndate = 2020-01-01T10:00:00\n+++\n\nBody\n",
+	},
+	1: {
+		in:      "+++\ndate = 2020-01-01T10:00:00\n+++\n\nBody\n",
+		offsets: true,
+		out:     "+++\ndate = 2020-01-01T09:00:00Z\n+++\n\nBody\n",
+	},
+	2: {
+		in:      "+++\ndate = 2020-01-01T10:00:00-05:00\n+++\n\n\nBody\n",
+		offsets: true,
+		out:     "+++\ndate = 2020-01-01T15:00:00Z\n+++\n\nBody\n",
+	},
+	3: {
+		in:  "---\ndate: 2020-01-01\n---\n\nBody\n",
+		out: "+++\ndate = 2020-01-01\n+++\n\nBody\n",
+	},
+	4: {
+		in:      "---\ndate: 2020-01-01\n---\n\nBody\n",
+		offsets: true,
+		out:     "+++\ndate = 2019-12-31T23:00:00Z\n+++\n\nBody\n",
+	},
+	5: {
+		in:  "+++\ndate = 2020-01-01T10:00:00\n+++\n\nBody\n",
+		out: "+++\ndate = 2020-01-01T10:00:00\n+++\n\nBody\n",
+	},
+}
+
+func TestConvert(t *testing.T) {
+	defer func(local *time.Location) {
+		time.Local = local
+	}(time.Local)
+	time.Local = time.FixedZone("EST", -5*60*60)
+	siteConfig := Config{location: time.FixedZone("CET", 60*60)}
+	logger := log.New(ioutil.Discard, "", 0)
+
+	for i, tc := range convertTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "blogsync")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			fname := filepath.Join(dir, "post.md")
+			err = ioutil.WriteFile(fname, []byte(tc.in), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := convertCmd(siteConfig, logger, logger)
+			args := []string{"-content", dir}
+			if tc.offsets {
+				args = append(args, "-offsets")
+			}
+			err = cmd.Flags.Parse(args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cmd.Run(cmd)
+			if err != nil {
+				t.Fatalf("error converting: %v", err)
+			}
+			out, err := ioutil.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tc.out {
+				t.Errorf("wrong output:\nwant=%q\n got=%q", tc.out, out)
+			}
+		})
+	}
+}
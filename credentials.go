@@ -0,0 +1,171 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const credentialsFile = "blogsync/credentials.json"
+
+// credentials are access tokens for one or more accounts on one or more
+// write.as compatible servers, keyed by API URL and then by username.
+type credentials struct {
+	// Current is the username of the account to use for each API URL.
+	Current map[string]string            `json:"current"`
+	Tokens  map[string]map[string]string `json:"tokens"`
+}
+
+// account is a single entry in the credentials file.
+type account struct {
+	URL      string
+	Username string
+	Token    string
+	Current  bool
+}
+
+// credentialsPath returns the path to the credentials file in the users
+// config directory.
+func credentialsPath(debug *log.Logger) string {
+	return userConfigPath(credentialsFile, debug)
+}
+
+// userConfigPath returns the path to the file name in the users config
+// directory.
+func userConfigPath(name string, debug *log.Logger) string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		debug.Printf("error fetching config directory: %v", err)
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
+
+// credentialsKey normalizes an API URL so that it can be used to look up
+// accounts.
+func credentialsKey(apiBase string) string {
+	return strings.TrimSuffix(apiBase, "/")
+}
+
+// loadCredentials reads the credentials file at fname.
+// If the file does not exist, empty credentials are returned.
+func loadCredentials(fname string) (*credentials, error) {
+	creds := &credentials{
+		Current: make(map[string]string),
+		Tokens:  make(map[string]map[string]string),
+	}
+	b, err := ioutil.ReadFile(fname)
+	switch {
+	case os.IsNotExist(err):
+		return creds, nil
+	case err != nil:
+		return nil, err
+	}
+	err = json.Unmarshal(b, creds)
+	if err != nil {
+		return nil, err
+	}
+	if creds.Current == nil {
+		creds.Current = make(map[string]string)
+	}
+	if creds.Tokens == nil {
+		creds.Tokens = make(map[string]map[string]string)
+	}
+	return creds, nil
+}
+
+// save writes the credentials to fname so that they are only readable by the
+// current user.
+func (c *credentials) save(fname string) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fname), 0700)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file, which is always created with 0600 permissions,
+	// and move it into place so that an existing file with looser permissions is
+	// replaced instead of being written to.
+	fd, err := ioutil.TempFile(filepath.Dir(fname), filepath.Base(fname)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(b)
+	if e := fd.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(fd.Name(), fname)
+	}
+	if err != nil {
+		os.Remove(fd.Name())
+	}
+	return err
+}
+
+// current returns the username and token of the current account for apiBase.
+func (c *credentials) current(apiBase string) (username, token string) {
+	key := credentialsKey(apiBase)
+	username = c.Current[key]
+	return username, c.Tokens[key][username]
+}
+
+// set stores the token for username and makes it the current account.
+func (c *credentials) set(apiBase, username, token string) {
+	key := credentialsKey(apiBase)
+	if c.Tokens[key] == nil {
+		c.Tokens[key] = make(map[string]string)
+	}
+	c.Tokens[key][username] = token
+	c.Current[key] = username
+}
+
+// remove deletes the token for username, and returns the removed token.
+func (c *credentials) remove(apiBase, username string) (token string, ok bool) {
+	key := credentialsKey(apiBase)
+	token, ok = c.Tokens[key][username]
+	if !ok {
+		return "", false
+	}
+	delete(c.Tokens[key], username)
+	if len(c.Tokens[key]) == 0 {
+		delete(c.Tokens, key)
+	}
+	if c.Current[key] == username {
+		delete(c.Current, key)
+	}
+	return token, true
+}
+
+// accounts returns every account in the credentials, sorted by URL and then
+// by username.
+func (c *credentials) accounts() []account {
+	var accts []account
+	for key, users := range c.Tokens {
+		for username, token := range users {
+			accts = append(accts, account{
+				URL:      key,
+				Username: username,
+				Token:    token,
+				Current:  c.Current[key] == username,
+			})
+		}
+	}
+	sort.Slice(accts, func(i, j int) bool {
+		if accts[i].URL != accts[j].URL {
+			return accts[i].URL < accts[j].URL
+		}
+		return accts[i].Username < accts[j].Username
+	})
+	return accts
+}
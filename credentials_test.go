@@ -0,0 +1,99 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCredentialsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blogsync")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, filepath.FromSlash(credentialsFile))
+
+	creds, err := loadCredentials(fname)
+	if err != nil {
+		t.Fatalf("error loading missing credentials: %v", err)
+	}
+	creds.set("https://write.as/api/", "alice", "a1")
+	creds.set("https://write.as/api", "bob", "b1")
+	creds.set("https://example.net/api", "alice", "a2")
+	err = creds.save(fname)
+	if err != nil {
+		t.Fatalf("error saving credentials: %v", err)
+	}
+
+	creds, err = loadCredentials(fname)
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	if username, token := creds.current("https://write.as/api"); username != "bob" || token != "b1" {
+		t.Errorf("wrong current account: want=bob/b1, got=%s/%s", username, token)
+	}
+	want := []account{
+		{URL: "https://example.net/api", Username: "alice", Token: "a2", Current: true},
+		{URL: "https://write.as/api", Username: "alice", Token: "a1"},
+		{URL: "https://write.as/api", Username: "bob", Token: "b1", Current: true},
+	}
+	if accts := creds.accounts(); !reflect.DeepEqual(accts, want) {
+		t.Errorf("wrong accounts: want=%+v, got=%+v", want, accts)
+	}
+
+	token, ok := creds.remove("https://write.as/api", "bob")
+	if !ok || token != "b1" {
+		t.Errorf("wrong removed token: want=b1, got=%q (%t)", token, ok)
+	}
+	if username, token := creds.current("https://write.as/api"); username != "" || token != "" {
+		t.Errorf("expected no current account after removing it, got %s/%s", username, token)
+	}
+	if _, ok = creds.remove("https://write.as/api", "bob"); ok {
+		t.Errorf("expected removing a missing account to fail")
+	}
+}
+
+func TestCredentialsSavePermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blogsync")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "credentials.json")
+
+	// An existing file with looser permissions must not keep them.
+	err = ioutil.WriteFile(fname, []byte("{}"), 0644)
+	if err != nil {
+		t.Fatalf("error creating credentials file: %v", err)
+	}
+	creds, err := loadCredentials(fname)
+	if err != nil {
+		t.Fatalf("error loading credentials: %v", err)
+	}
+	creds.set("https://write.as/api", "alice", "a1")
+	err = creds.save(fname)
+	if err != nil {
+		t.Fatalf("error saving credentials: %v", err)
+	}
+	info, err := os.Stat(fname)
+	if err != nil {
+		t.Fatalf("error reading credentials file info: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("wrong permissions: want=%o, got=%o", 0600, perm)
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("error reading temp dir: %v", err)
+	}
+	if len(infos) != 1 {
+		t.Errorf("expected temporary files to be cleaned up, found %d files", len(infos))
+	}
+}
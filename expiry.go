@@ -0,0 +1,188 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/writeas/go-writeas/v2"
+)
+
+// Policies for handling the posts of pages with an expiryDate in the past.
+const (
+	// expiryDelete deletes the post.
+	expiryDelete = "delete"
+
+	// expiryUnlist moves the post out of its collection, leaving it as an
+	// unlisted post that can only be found by people who already have its URL.
+	expiryUnlist = "unlist"
+)
+
+// unlistedFile is the file in the users config directory that records which
+// posts were unlisted.
+const unlistedFile = "blogsync/unlisted.json"
+
+// unlistedPosts records the IDs of posts that were unlisted because their page
+// expired, keyed by API URL and then by post ID, with the path of the page as
+// the value.
+// Unlisted posts no longer have a collection or slug, so this is the only way to
+// match them to their pages again.
+type unlistedPosts map[string]map[string]string
+
+// loadUnlisted reads the record of unlisted posts from fname.
+// If the file does not exist, an empty record is returned.
+func loadUnlisted(fname string) (unlistedPosts, error) {
+	unlisted := make(unlistedPosts)
+	b, err := ioutil.ReadFile(fname)
+	switch {
+	case os.IsNotExist(err):
+		return unlisted, nil
+	case err != nil:
+		return nil, err
+	}
+	err = json.Unmarshal(b, &unlisted)
+	if err != nil {
+		return nil, err
+	}
+	return unlisted, nil
+}
+
+// save writes the record of unlisted posts to fname.
+func (u unlistedPosts) save(fname string) error {
+	b, err := json.MarshalIndent(u, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(fname), 0700)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fname, b, 0644)
+}
+
+// server returns the unlisted posts for apiBase, creating the map if it does
+// not exist yet.
+func (u unlistedPosts) server(apiBase string) map[string]string {
+	key := credentialsKey(apiBase)
+	if u[key] == nil {
+		u[key] = make(map[string]string)
+	}
+	return u[key]
+}
+
+// expiryPolicy returns the policy for expired pages configured in siteConfig,
+// or an error if it is not a known policy.
+func expiryPolicy(siteConfig Config) (string, error) {
+	policy := orDef(siteConfig.ExpiryPolicy, expiryDelete)
+	switch policy {
+	case expiryDelete, expiryUnlist:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown expiry policy %q, expected %q or %q", siteConfig.ExpiryPolicy, expiryDelete, expiryUnlist)
+}
+
+// expiredPost is a remote post for a page that has expired.
+type expiredPost struct {
+	writeas.Post
+	path   string
+	expiry time.Time
+}
+
+// unlisted reports whether the post has already been removed from its
+// collection.
+func (p expiredPost) unlisted() bool {
+	return p.Collection == nil
+}
+
+// splitExpired removes the posts for pages that expired before now from posts
+// and returns them separately.
+// Posts are matched to pages by their collection and slug, or if they were
+// already unlisted by the post IDs in unlisted, which maps IDs to page paths.
+func splitExpired(posts []writeas.Post, pages pageIndex, unlisted map[string]string, opts publishOptions, now time.Time) ([]writeas.Post, []expiredPost) {
+	bySlug := make(map[slugKey]string)
+	for pagePath, page := range pages {
+		if page.expiry.IsZero() || page.expiry.After(now) {
+			continue
+		}
+		bySlug[slugKey{collection: opts.remoteCollection(page.collection), slug: page.slug}] = pagePath
+	}
+	if len(bySlug) == 0 {
+		return posts, nil
+	}
+
+	remaining := make([]writeas.Post, 0, len(posts))
+	var expired []expiredPost
+	for _, post := range posts {
+		var pagePath string
+		var ok bool
+		if post.Collection != nil {
+			pagePath, ok = bySlug[slugKey{collection: post.Collection.Alias, slug: post.Slug}]
+		} else {
+			pagePath, ok = bySlug[slugKey{slug: post.Slug}]
+			if unlistedPath, wasUnlisted := unlisted[post.ID]; !ok && wasUnlisted {
+				page := pages[unlistedPath]
+				pagePath, ok = unlistedPath, !page.expiry.IsZero() && !page.expiry.After(now)
+			}
+		}
+		if !ok {
+			remaining = append(remaining, post)
+			continue
+		}
+		expired = append(expired, expiredPost{
+			Post:   post,
+			path:   pagePath,
+			expiry: pages[pagePath].expiry,
+		})
+	}
+	return remaining, expired
+}
+
+// removeExpired deletes or unlists each expired post depending on policy.
+// When unlisting, api is used to move posts out of their collection.
+// The IDs of posts that are unlisted are added to unlisted, and the IDs of
+// posts that are deleted are removed from it.
+func removeExpired(expired []expiredPost, policy string, dryRun bool, unlisted map[string]string, client *writeas.Client, api *apiClient, logger, debug *log.Logger) {
+	for _, post := range expired {
+		expiry := post.expiry.Format(noticeTimeFormat)
+		switch {
+		case policy == expiryUnlist && post.unlisted():
+			debug.Printf("%s expired on %s and post %q is already unlisted, skipping", post.path, expiry, post.ID)
+			continue
+		case policy == expiryUnlist:
+			logger.Printf("%s expired on %s, unlisting post %q", post.path, expiry, post.Slug)
+			if dryRun {
+				continue
+			}
+			if api == nil {
+				logger.Printf("error unlisting post %q: no API client configured", post.Slug)
+				continue
+			}
+			err := api.do(http.MethodPost, "/posts/disperse", []string{post.ID}, nil)
+			if err != nil {
+				logger.Printf("error unlisting post %q: %v", post.Slug, err)
+				continue
+			}
+			unlisted[post.ID] = post.path
+		default:
+			logger.Printf("%s expired on %s, deleting post %q", post.path, expiry, orDef(post.Slug, post.ID))
+			if dryRun {
+				continue
+			}
+			err := client.DeletePost(post.ID, post.Token)
+			if err != nil {
+				logger.Printf("error deleting post %q: %v", orDef(post.Slug, post.ID), err)
+				continue
+			}
+			delete(unlisted, post.ID)
+		}
+	}
+}
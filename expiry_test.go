@@ -0,0 +1,94 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/writeas/go-writeas/v2"
+)
+
+// postIDs returns the sorted IDs of the posts on the server.
+func (pt *publishTest) postIDs() []string {
+	var ids []string
+	for _, post := range pt.srv.Posts() {
+		ids = append(ids, post.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// anonPost creates a post that is not in a collection and returns its ID.
+func (pt *publishTest) anonPost(title string) string {
+	pt.t.Helper()
+	post, err := pt.client.CreatePost(&writeas.PostParams{Title: title, Content: "Unrelated"})
+	if err != nil {
+		pt.t.Fatalf("error creating anonymous post: %v", err)
+	}
+	return post.ID
+}
+
+func TestPublishExpiredDelete(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("old.md", `title = "Old"`, "Hello")
+	pt.write("new.md", `title = "New"`, "World")
+	pt.publish(nil)
+	newID := pt.posts()["blog/new"].ID
+	// An unrelated post with the same title as the expired page must not be
+	// matched to it.
+	anonID := pt.anonPost("Old")
+
+	pt.write("old.md", "title = \"Old\"\nexpiryDate = 2000-01-01T00:00:00Z", "Hello")
+	pt.publish(nil)
+	want := []string{anonID, newID}
+	sort.Strings(want)
+	if ids := pt.postIDs(); !reflect.DeepEqual(ids, want) {
+		t.Errorf("wrong posts after expiry: want=%q, got=%q", want, ids)
+	}
+}
+
+func TestPublishExpiredUnlist(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	pt.config.ExpiryPolicy = expiryUnlist
+
+	pt.write("old.md", `title = "Old"`, "Hello")
+	pt.publish(nil)
+	oldID := pt.posts()["blog/old"].ID
+	anonID := pt.anonPost("Old")
+
+	pt.write("old.md", "title = \"Old\"\nexpiryDate = 2000-01-01T00:00:00Z", "Hello")
+	pt.publish(nil)
+	posts := pt.srv.Posts()
+	if len(posts) != 2 {
+		t.Fatalf("wrong number of posts after unlisting: want=2, got=%d", len(posts))
+	}
+	for _, post := range posts {
+		if post.Collection != nil {
+			t.Errorf("expected post %q to be unlisted, found it in collection %q", post.ID, post.Collection.Alias)
+		}
+	}
+
+	// Unlisted posts are still matched to their page, so publishing again does
+	// not treat them as orphans.
+	pt.publish(nil)
+	want := []string{anonID, oldID}
+	sort.Strings(want)
+	if !reflect.DeepEqual(pt.postIDs(), want) {
+		t.Errorf("wrong posts after publishing again: want=%q, got=%q", want, pt.postIDs())
+	}
+
+	// Switching to the delete policy deletes the unlisted post by its recorded
+	// ID and leaves the unrelated post with the same title alone.
+	pt.config.ExpiryPolicy = expiryDelete
+	pt.publish(nil)
+	if want = []string{anonID}; !reflect.DeepEqual(pt.postIDs(), want) {
+		t.Errorf("wrong posts after deleting: want=%q, got=%q", want, pt.postIDs())
+	}
+}
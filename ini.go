@@ -0,0 +1,116 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// iniFile is a minimal representation of the INI files used to configure
+// writefreely.
+// The order of sections and keys is preserved but comments are not.
+type iniFile struct {
+	sections []*iniSection
+}
+
+type iniSection struct {
+	name   string
+	keys   []string
+	values map[string]string
+}
+
+// parseINI parses the sections and keys in r.
+// Keys that appear before the first section are put in a section with an
+// empty name.
+func parseINI(r io.Reader) (*iniFile, error) {
+	f := &iniFile{}
+	section := ""
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			f.section(section)
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			return nil, fmt.Errorf("line %d: expected key = value, got %q", lineNo, line)
+		}
+		f.set(section, strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:]))
+	}
+	return f, scanner.Err()
+}
+
+// section returns the section with the given name, adding it if it does not
+// exist.
+func (f *iniFile) section(name string) *iniSection {
+	for _, s := range f.sections {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &iniSection{name: name, values: make(map[string]string)}
+	f.sections = append(f.sections, s)
+	return s
+}
+
+// get returns the value of key in section.
+func (f *iniFile) get(section, key string) (string, bool) {
+	for _, s := range f.sections {
+		if s.name == section {
+			v, ok := s.values[key]
+			return v, ok
+		}
+	}
+	return "", false
+}
+
+// set sets the value of key in section, adding either if they do not exist.
+func (f *iniFile) set(section, key, value string) {
+	s := f.section(section)
+	if _, ok := s.values[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.values[key] = value
+}
+
+// merge sets every key from other in f.
+func (f *iniFile) merge(other *iniFile) {
+	for _, s := range other.sections {
+		f.section(s.name)
+		for _, key := range s.keys {
+			f.set(s.name, key, s.values[key])
+		}
+	}
+}
+
+// WriteTo writes the INI file to w.
+func (f *iniFile) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for i, s := range f.sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		if s.name != "" {
+			fmt.Fprintf(&b, "[%s]\n", s.name)
+		}
+		for _, key := range s.keys {
+			if v := s.values[key]; v != "" {
+				fmt.Fprintf(&b, "%s = %s\n", key, v)
+			} else {
+				fmt.Fprintf(&b, "%s =\n", key)
+			}
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testINI = `; writefreely config
+top = 1
+
+[server]
+port = 8080
+bind =   localhost
+
+# comment
+[app]
+site_name = Blog
+single_user = true
+`
+
+func TestParseINI(t *testing.T) {
+	f, err := parseINI(strings.NewReader(testINI))
+	if err != nil {
+		t.Fatalf("error parsing INI: %v", err)
+	}
+	for _, kv := range [][3]string{
+		{"", "top", "1"},
+		{"server", "port", "8080"},
+		{"server", "bind", "localhost"},
+		{"app", "site_name", "Blog"},
+	} {
+		if v, ok := f.get(kv[0], kv[1]); !ok || v != kv[2] {
+			t.Errorf("wrong value for %s.%s: want=%q, got=%q (%t)", kv[0], kv[1], kv[2], v, ok)
+		}
+	}
+	if _, ok := f.get("server", "missing"); ok {
+		t.Errorf("expected missing key not to be found")
+	}
+
+	_, err = parseINI(strings.NewReader("[server]\nport\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected invalid line to be reported, got %v", err)
+	}
+}
+
+func TestINIMerge(t *testing.T) {
+	f, err := parseINI(strings.NewReader(testINI))
+	if err != nil {
+		t.Fatalf("error parsing INI: %v", err)
+	}
+	other, err := parseINI(strings.NewReader("[app]\nsingle_user = false\nfederation =\n\n[database]\ntype = sqlite3\n"))
+	if err != nil {
+		t.Fatalf("error parsing INI to merge: %v", err)
+	}
+	f.merge(other)
+
+	var b strings.Builder
+	_, err = f.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("error writing INI: %v", err)
+	}
+	// Existing keys keep their position and new keys and sections are appended.
+	want := `top = 1
+
+[server]
+port = 8080
+bind = localhost
+
+[app]
+site_name = Blog
+single_user = false
+federation =
+
+[database]
+type = sqlite3
+`
+	if b.String() != want {
+		t.Errorf("wrong merged INI:\nwant=%q,\n got=%q", want, b.String())
+	}
+}
@@ -9,8 +9,8 @@ import (
 	"bufio"
 	"bytes"
 	"io"
-	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
@@ -24,21 +24,18 @@ const (
 	HeaderYAML = "---\n"
 )
 
-// WalkPages walks the file tree rooted at root and calls walkFn for each page.
-// It skips any files that do not end in the extension ".markdown" or ".md".
-func WalkPages(root string, walkFn filepath.WalkFunc) error {
-	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		ext := filepath.Ext(path)
-		switch {
-		case ext != ".md" && ext != ".markdown":
-			// Skip non-markdown files
-			return nil
-		case info.IsDir():
-			// Decend into directories, but don't call walkFn for them.
+// WalkPages walks the file tree rooted at root and calls walkFn for each page
+// that should be published.
+// It skips any files that do not end in the extension ".markdown" or ".md",
+// section pages ("_index.md"), headless bundles, Markdown files that are
+// resources in a leaf bundle, and files matched by ignore.
+// To find the resources for each page or to include section pages, use Walk.
+func WalkPages(root string, ignore *Ignore, walkFn filepath.WalkFunc) error {
+	return Walk(root, ignore, func(page Page, err error) error {
+		if err == nil && !page.Published() {
 			return nil
 		}
-
-		return walkFn(path, info, err)
+		return walkFn(page.Path, page.Info, err)
 	})
 }
 
@@ -79,8 +76,33 @@ func (m Metadata) Decode(f io.Reader) (string, error) {
 	switch header {
 	case HeaderTOML:
 		err = toml.Unmarshal(metaBuf.Bytes(), &m)
+		if err != nil {
+			return header, err
+		}
+		// The TOML decoder parses dates without an offset in the systems local
+		// time zone, which makes them impossible to tell apart from dates that
+		// have an explicit offset that happens to match it, so find them in the
+		// raw TOML instead.
+		for key, raw := range localTOMLTimes(metaBuf.Bytes()) {
+			if t, ok := m[key].(time.Time); ok {
+				m[key] = LocalTime{Time: t, Raw: raw}
+			}
+		}
 	case HeaderYAML:
 		err = yaml.Unmarshal(metaBuf.Bytes(), m)
+		if err != nil {
+			return header, err
+		}
+		// The YAML decoder parses dates without an offset in UTC, so find them
+		// in the raw YAML for the same reason.
+		for key, raw := range localYAMLTimes(metaBuf.Bytes()) {
+			if _, ok := m[key].(time.Time); ok {
+				local, err := ParseLocalTime(raw)
+				if err == nil {
+					m[key] = local
+				}
+			}
+		}
 	}
 	return header, err
 }
@@ -116,22 +138,59 @@ func (m Metadata) GetBool(key string) bool {
 
 var fmts = []string{time.RFC3339, time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
 
+// TimeZoneKey is the metadata key that may be used to override the time zone
+// used to parse dates on a single page.
+const TimeZoneKey = "timeZone"
+
+// Location returns the time zone named by the TimeZoneKey in the metadata.
+// If the key is not set, or the time zone cannot be loaded, def is returned.
+func (m Metadata) Location(def *time.Location) (*time.Location, error) {
+	tz := m.GetString(TimeZoneKey)
+	if tz == "" {
+		return def, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return def, err
+	}
+	return loc, nil
+}
+
 // GetTime parses the metadata value for key and returns it as a timestamp.
-// If the underlying value is not already a time.Time, or a string that can be
-// parsed into a valid time, a zero value will be returned.
+// Dates without a UTC offset are assumed to be in the time zone set in the
+// metadata or UTC.
+// For more information see GetTimeIn.
 func (m Metadata) GetTime(key string) time.Time {
+	return m.GetTimeIn(key, time.UTC)
+}
+
+// GetTimeIn parses the metadata value for key and returns it as a timestamp.
+// If the underlying value is not already a time.Time or LocalTime, or a string
+// that can be parsed into a valid time, a zero value will be returned.
+//
+// Dates without a UTC offset are assumed to be in the time zone set by the
+// TimeZoneKey in the metadata or, if none is set, in loc.
+// Dates with an offset are returned unchanged.
+func (m Metadata) GetTimeIn(key string, loc *time.Location) time.Time {
 	var zero time.Time
 	val, ok := m.get(key)
 	if !ok {
 		return zero
 	}
 
+	if loc == nil {
+		loc = time.UTC
+	}
+	loc, _ = m.Location(loc)
+
 	switch t := val.(type) {
 	case time.Time:
 		return t
+	case LocalTime:
+		return t.InLocation(loc)
 	case string:
 		for _, timeFmt := range fmts {
-			out, err := time.Parse(timeFmt, t)
+			out, err := time.ParseInLocation(timeFmt, t, loc)
 			if err == nil {
 				return out
 			}
@@ -140,40 +199,131 @@ func (m Metadata) GetTime(key string) time.Time {
 	return zero
 }
 
-// Slug attempts to guess the slug that the final page will have by checking if
-// the metadata has a "slug" attribute and, if not, generating one from the
-// filename (the full path should be passed so that trees such as
-// "mypost/index.md" can be recognized as a post called 'mypost' and not
-// 'index').
-func Slug(filename string, meta Metadata) string {
-	// First see if the user has explicitly set a slug.
-	slug := meta.GetString("slug")
+// localFmts are the formats of dates without a UTC offset.
+var localFmts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+// LocalTime is a date, or date and time, that was written without a UTC
+// offset.
+// The embedded time is the date in the systems local time zone, as it would
+// have been parsed by the TOML decoder.
+type LocalTime struct {
+	time.Time
+
+	// Raw is the date as it was written.
+	Raw string
+}
+
+// ParseLocalTime parses a date, or date and time, without a UTC offset.
+// If s has an offset or is not a date an error is returned.
+func ParseLocalTime(s string) (LocalTime, error) {
+	var err error
+	for _, timeFmt := range localFmts {
+		var t time.Time
+		t, err = time.ParseInLocation(timeFmt, s, time.Local)
+		if err == nil {
+			return LocalTime{Time: t, Raw: s}, nil
+		}
+	}
+	return LocalTime{}, err
+}
 
-	// If not, use the post title.
-	if slug == "" {
-		slug = meta.GetString("title")
+// InLocation returns the time with the same date and clock time in loc.
+// Unlike In, this changes the instant that the time represents.
+func (t LocalTime) InLocation(loc *time.Location) time.Time {
+	for _, timeFmt := range localFmts {
+		out, err := time.ParseInLocation(timeFmt, t.Raw, loc)
+		if err == nil {
+			return out
+		}
 	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+var (
+	localTOMLTime   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}(\.\d+)?)?$`)
+	tomlTableHeader = regexp.MustCompile(`^\[\[?[^\[\]]*\]\]?$`)
+)
+
+// localTOMLTimes returns the top level keys in the TOML document b that are set
+// to a date, or date and time, without a UTC offset, mapped to the date as it
+// was written.
+func localTOMLTimes(b []byte) map[string]string {
+	times := make(map[string]string)
+	var multiline string
+	for _, line := range strings.Split(string(b), "\n") {
+		if multiline != "" {
+			if strings.Count(line, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if tomlTableHeader.MatchString(line) {
+			// Only dates in the top level table are metadata that we care about.
+			break
+		}
+		for _, quote := range []string{`"""`, `'''`} {
+			if strings.Count(line, quote)%2 == 1 {
+				multiline = quote
+			}
+		}
 
-	// If there is no post title, things will probably fail, but just in case try
-	// to guess the slug from the filename or path.
-	if slug == "" {
-		// If the slug is not set in the metadata, extract it form the filename or
-		// the last segment of the path.
-		base := filepath.Base(filename)
-		if base == "index.md" {
-			slug = filepath.Base(filepath.Dir(filename))
+		idx := strings.Index(line, "=")
+		if idx == -1 {
+			continue
 		}
-		if slug == "" {
-			slug = strings.TrimSuffix(base, filepath.Ext(base))
+		key := strings.TrimSpace(line[:idx])
+		val := strings.TrimSpace(line[idx+1:])
+		if len(key) > 1 && (key[0] == '"' || key[0] == '\'') && key[len(key)-1] == key[0] {
+			key = key[1 : len(key)-1]
+		}
+		if localTOMLTime.MatchString(val) {
+			times[key] = val
 		}
 	}
+	return times
+}
 
-	// Try to make sure the slug is actually valid as a slug, even if it comes
-	// from a title or path that won't necessarily be.
-	const sep = "-"
-	slug = strings.ToLower(slug)
-	slug = strings.ReplaceAll(slug, " ", sep)
-	slug = strings.ReplaceAll(slug, string(filepath.Separator), sep)
+// localYAMLTimes returns the top level keys in the YAML document b that are set
+// to a scalar that looks like a timestamp without a UTC offset, mapped to the
+// timestamp as it was written.
+func localYAMLTimes(b []byte) map[string]string {
+	times := make(map[string]string)
+	var nodes map[string]yaml.Node
+	err := yaml.Unmarshal(b, &nodes)
+	if err != nil {
+		return times
+	}
+	for key, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			continue
+		}
+		if _, err := ParseLocalTime(node.Value); err == nil {
+			times[key] = node.Value
+		}
+	}
+	return times
+}
 
-	return slug
+// stripTOMLComment removes a trailing comment from line, unless the "#" is in a
+// string.
+func stripTOMLComment(line string) string {
+	var quote rune
+	var escaped bool
+	for i, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#':
+			return line[:i]
+		}
+	}
+	return line
 }
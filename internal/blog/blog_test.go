@@ -0,0 +1,107 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog_test
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+var getTimeTestCases = [...]struct {
+	meta string
+	key  string
+	want string
+}{
+	0: {meta: "+++\ndate = 2020-01-01T10:00:00Z\n+++\n", want: "2020-01-01T10:00:00Z"},
+	1: {meta: "+++\ndate = 2020-01-01T10:00:00-05:00\n+++\n", want: "2020-01-01T15:00:00Z"},
+	2: {meta: "+++\ndate = 2020-01-01T10:00:00\n+++\n", want: "2020-01-01T09:00:00Z"},
+	3: {meta: "+++\ndate = 2020-01-01\n+++\n", want: "2019-12-31T23:00:00Z"},
+	4: {meta: "+++\ndate = 2020-01-01T10:00:00 # a comment\n+++\n", want: "2020-01-01T09:00:00Z"},
+	5: {meta: "+++\n\"date\" = 2020-01-01T10:00:00\n+++\n", want: "2020-01-01T09:00:00Z"},
+	6: {meta: "+++\ndate = 2020-01-01T10:00:00\ntimeZone = \"UTC\"\n+++\n", want: "2020-01-01T10:00:00Z"},
+	7: {meta: "+++\ndate = \"2020-01-01T10:00:00\"\n+++\n", want: "2020-01-01T09:00:00Z"},
+	8: {meta: "+++\ndate = \"2020-01-01T10:00:00-05:00\"\n+++\n", want: "2020-01-01T15:00:00Z"},
+	9: {
+		// Dates in other tables are not metadata, even if they have the same key.
+		meta: "+++\ndate = 2020-01-01T10:00:00-05:00\n[params]\ndate = 2020-01-01T10:00:00\n+++\n",
+		want: "2020-01-01T15:00:00Z",
+	},
+	10: {
+		meta: "+++\nsummary = \"\"\"\ndate = 2020-01-01T10:00:00\n\"\"\"\ndate = 2020-01-01T10:00:00-05:00\n+++\n",
+		want: "2020-01-01T15:00:00Z",
+	},
+	11: {meta: "---\ndate: 2020-01-01T10:00:00-05:00\n---\n", want: "2020-01-01T15:00:00Z"},
+	12: {meta: "---\ndate: 2020-01-01T10:00:00\n---\n", want: "2020-01-01T09:00:00Z"},
+	13: {meta: "---\ndate: 2020-01-01 10:00:00\n---\n", want: "2020-01-01T09:00:00Z"},
+	14: {meta: "---\ndate: 2020-01-01\n---\n", want: "2019-12-31T23:00:00Z"},
+	15: {meta: "+++\ndate = \"not a date\"\n+++\n", want: "0001-01-01T00:00:00Z"},
+	16: {meta: "+++\ntitle = \"No date\"\n+++\n", want: "0001-01-01T00:00:00Z"},
+	17: {meta: "+++\nlastmod = 2020-01-01T10:00:00\n+++\n", key: "lastmod", want: "2020-01-01T09:00:00Z"},
+}
+
+func TestGetTimeIn(t *testing.T) {
+	// Use a local time zone that matches the offset of some of the dates, which
+	// makes the TOML decoder return them in the local time zone.
+	defer func(local *time.Location) {
+		time.Local = local
+	}(time.Local)
+	time.Local = time.FixedZone("EST", -5*60*60)
+	paris := time.FixedZone("CET", 60*60)
+
+	for i, tc := range getTimeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			meta := make(blog.Metadata)
+			_, err := meta.Decode(strings.NewReader(tc.meta))
+			if err != nil {
+				t.Fatalf("error decoding metadata: %v", err)
+			}
+			key := tc.key
+			if key == "" {
+				key = "date"
+			}
+			got := meta.GetTimeIn(key, paris).UTC().Format(time.RFC3339)
+			if got != tc.want {
+				t.Errorf("wrong time: want=%s, got=%s", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestGetTimeInvalidZone(t *testing.T) {
+	meta := blog.Metadata{"date": "2020-01-01T10:00:00", blog.TimeZoneKey: "Not/AZone"}
+	loc, err := meta.Location(time.UTC)
+	if err == nil {
+		t.Errorf("expected an error loading an invalid time zone")
+	}
+	if loc != time.UTC {
+		t.Errorf("expected the default time zone to be used, got %s", loc)
+	}
+	got := meta.GetTimeIn("date", time.UTC).Format(time.RFC3339)
+	if want := "2020-01-01T10:00:00Z"; got != want {
+		t.Errorf("wrong time: want=%s, got=%s", want, got)
+	}
+}
+
+func TestLocalTimeTemplateCompat(t *testing.T) {
+	meta := make(blog.Metadata)
+	_, err := meta.Decode(strings.NewReader("+++\ndate = 2020-01-02T10:00:00\n+++\n"))
+	if err != nil {
+		t.Fatalf("error decoding metadata: %v", err)
+	}
+	local, ok := meta["date"].(blog.LocalTime)
+	if !ok {
+		t.Fatalf("expected a LocalTime, got %T", meta["date"])
+	}
+	if got := local.Format("2006-01-02 15:04"); got != "2020-01-02 10:00" {
+		t.Errorf("wrong clock time: got=%s", got)
+	}
+	if local.Raw != "2020-01-02T10:00:00" {
+		t.Errorf("wrong raw date: got=%s", local.Raw)
+	}
+}
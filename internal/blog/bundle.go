@@ -0,0 +1,300 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BundleType is the kind of Hugo page bundle that a page is the root of.
+type BundleType int
+
+// A list of the bundle types understood by Walk.
+const (
+	// NoBundle is a regular page that is a single file.
+	NoBundle BundleType = iota
+
+	// LeafBundle is a page named "index.md" and any other files in the same
+	// directory and its subdirectories.
+	// Other Markdown files in the bundle are resources, not pages.
+	LeafBundle
+
+	// BranchBundle is a section page named "_index.md" and any non-Markdown
+	// files in the same directory.
+	// Branch bundles are list pages and are not published.
+	BranchBundle
+)
+
+// Page is a content file that would be rendered by Hugo.
+type Page struct {
+	// Path is the path to the content file, including the root passed to Walk.
+	Path string
+	Info os.FileInfo
+
+	// Bundle is the kind of bundle that the page is the root of, if any.
+	Bundle BundleType
+
+	// Headless is true if the page is a leaf bundle that sets "headless" or sets
+	// "render" to false or "never" in its "_build" options.
+	Headless bool
+
+	// Resources is a sorted list of the other files in the page bundle relative
+	// to the directory containing the page.
+	Resources []string
+}
+
+// Published reports whether the page is a regular page that should be
+// published, as opposed to a section page or a headless bundle.
+func (p Page) Published() bool {
+	return p.Bundle != BranchBundle && !p.Headless
+}
+
+// Walk walks the file tree rooted at root and calls walkFn for each page,
+// including section pages and headless bundles.
+// Markdown files that are resources in a leaf bundle are not pages and are
+// skipped, as are any files that do not end in the extension ".markdown" or
+// ".md" and any files or directories matched by ignore.
+func Walk(root string, ignore *Ignore, walkFn func(page Page, err error) error) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return walkFn(Page{Path: path, Info: info}, err)
+		}
+
+		if ignore.Match(path, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() {
+			// Index pages are handled when we see their directory, and any other
+			// non-markdown file is skipped.
+			if !isContent(path) || isIndex(path, true) || isIndex(path, false) {
+				return nil
+			}
+			return walkFn(Page{Path: path, Info: info}, nil)
+		}
+
+		pages, err := bundle(path, ignore)
+		if err != nil {
+			return err
+		}
+		for _, page := range pages {
+			err = walkFn(page, nil)
+			if err != nil {
+				return err
+			}
+		}
+		if len(pages) > 0 && pages[0].Bundle == LeafBundle {
+			// Everything else in the leaf bundle is a resource, not a page.
+			return filepath.SkipDir
+		}
+		return nil
+	})
+}
+
+// IsPage reports whether the file at path, which is in the content tree rooted
+// at root, is a page that would be passed to the walk function by WalkPages.
+func IsPage(root, path string, ignore *Ignore) bool {
+	if !isContent(path) || isIndex(path, false) || ignore.Match(path, false) {
+		return false
+	}
+
+	// If this is the index page of a leaf bundle, check if it's headless.
+	dir := filepath.Dir(path)
+	if isIndex(path, true) {
+		page, ok, err := bundlePage(path, ignore)
+		return err == nil && ok && page.Published()
+	}
+
+	// Otherwise, make sure we're not a resource in any leaf bundle between the
+	// file and the root of the tree.
+	root = filepath.Clean(root)
+	for {
+		pages, _ := bundle(dir, ignore)
+		if len(pages) > 0 && pages[0].Bundle == LeafBundle {
+			return false
+		}
+		parent := filepath.Dir(dir)
+		if dir == root || parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return true
+}
+
+// ReadPage returns information about the page at path, including its bundle
+// resources that are not matched by ignore if it is the index page of a bundle.
+func ReadPage(path string, ignore *Ignore) (Page, error) {
+	if isIndex(path, true) || isIndex(path, false) {
+		page, ok, err := bundlePage(path, ignore)
+		if err != nil || ok {
+			return page, err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Page{Path: path}, err
+	}
+	return Page{Path: path, Info: info}, nil
+}
+
+// bundle checks if dir is a page bundle and returns the pages for its index
+// files.
+// Multilingual sites may have more than one index in a bundle, one for each
+// language (eg. "index.md" and "index.fr.md"), that share the same resources.
+func bundle(dir string, ignore *Ignore) (pages []Page, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var bundleType BundleType
+	indexes := make(map[string]bool)
+	for _, info := range infos {
+		name := filepath.Join(dir, info.Name())
+		if info.IsDir() || !isContent(name) || ignore.Match(name, false) {
+			continue
+		}
+		var typ BundleType
+		switch {
+		case isIndex(name, true):
+			typ = LeafBundle
+		case isIndex(name, false):
+			typ = BranchBundle
+		default:
+			continue
+		}
+		// A leaf index takes precedence over any branch index in the same
+		// directory.
+		if bundleType != NoBundle && typ != bundleType {
+			if typ == BranchBundle {
+				continue
+			}
+			pages = pages[:0]
+		}
+		bundleType = typ
+		pages = append(pages, Page{Path: name, Info: info, Bundle: typ})
+	}
+	if len(pages) == 0 {
+		return nil, nil
+	}
+	for _, page := range pages {
+		indexes[page.Path] = true
+	}
+
+	var resources []string
+	switch bundleType {
+	case LeafBundle:
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case err != nil:
+				return err
+			case ignore.Match(path, info.IsDir()):
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			case info.IsDir() || indexes[path]:
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	case BranchBundle:
+		for _, info := range infos {
+			if info.IsDir() || isContent(info.Name()) || ignore.Match(filepath.Join(dir, info.Name()), false) {
+				continue
+			}
+			resources = append(resources, info.Name())
+		}
+	}
+	sort.Strings(resources)
+
+	for i := range pages {
+		pages[i].Resources = resources
+		if bundleType == LeafBundle {
+			pages[i].Headless = isHeadless(pages[i].Path)
+		}
+	}
+	return pages, nil
+}
+
+// bundlePage returns the bundle page for the index file at path.
+func bundlePage(path string, ignore *Ignore) (page Page, ok bool, err error) {
+	pages, err := bundle(filepath.Dir(path), ignore)
+	if err != nil {
+		return page, false, err
+	}
+	path = filepath.Clean(path)
+	for _, page := range pages {
+		if page.Path == path {
+			return page, true, nil
+		}
+	}
+	return page, false, nil
+}
+
+// isHeadless decodes the metadata in the index page of a leaf bundle and
+// reports whether the bundle is headless.
+// If the metadata cannot be decoded the bundle is assumed to not be headless
+// and the error will be reported when the page is published.
+func isHeadless(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	meta := make(Metadata)
+	_, err = meta.Decode(f)
+	if err != nil {
+		return false
+	}
+	if meta.GetBool("headless") {
+		return true
+	}
+	build, _ := meta["_build"].(map[string]interface{})
+	switch render := build["render"].(type) {
+	case bool:
+		return !render
+	case string:
+		return render == "never" || render == "false"
+	}
+	return false
+}
+
+// isContent reports whether path is a Markdown file.
+func isContent(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".md" || ext == ".markdown"
+}
+
+// isIndex reports whether path is the index page of a leaf bundle
+// ("index.md"), or of a branch bundle ("_index.md") if leaf is false.
+// The index may also contain a language code (eg. "index.fr.md").
+func isIndex(path string, leaf bool) bool {
+	name := "_index"
+	if leaf {
+		name = "index"
+	}
+	base := filepath.Base(path)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	return base == name || strings.HasPrefix(base, name+".") && !strings.Contains(base[len(name)+1:], ".")
+}
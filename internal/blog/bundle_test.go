@@ -0,0 +1,179 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+// bundleTree is a content tree with each kind of page bundle.
+var bundleTree = map[string]string{
+	"post.md":                      "+++\ntitle = \"Post\"\n+++\n",
+	"notes.txt":                    "not content",
+	"drafts/wip.md":                "+++\ntitle = \"WIP\"\n+++\n",
+	"leaf/index.md":                "+++\ntitle = \"Leaf\"\n+++\n",
+	"leaf/index.fr.md":             "+++\ntitle = \"Feuille\"\n+++\n",
+	"leaf/image.png":               "png",
+	"leaf/extra.md":                "+++\ntitle = \"Resource\"\n+++\n",
+	"leaf/sub/data.json":           "{}",
+	"headless/index.md":            "+++\nheadless = true\n+++\n",
+	"unrendered/index.md":          "+++\n[_build]\nrender = \"never\"\n+++\n",
+	"section/_index.md":            "+++\ntitle = \"Section\"\n+++\n",
+	"section/cover.jpg":            "jpg",
+	"section/child.md":             "+++\ntitle = \"Child\"\n+++\n",
+	"section/nested/index.md":      "+++\ntitle = \"Nested\"\n+++\n",
+	"section/nested/resource.md":   "+++\ntitle = \"Resource\"\n+++\n",
+	"both/_index.md":               "+++\ntitle = \"Branch\"\n+++\n",
+	"both/index.md":                "+++\ntitle = \"Leaf wins\"\n+++\n",
+	blog.IgnoreFile:                "drafts/\n",
+	"section/nested/sub/deeper.md": "+++\ntitle = \"Deeper\"\n+++\n",
+}
+
+// writeTree creates a temporary content tree containing files and returns its
+// root and the ignore patterns loaded from it.
+func writeTree(t *testing.T, files map[string]string) (string, *blog.Ignore) {
+	t.Helper()
+	root, err := ioutil.TempDir("", "blogsync")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	for name, content := range files {
+		fname := filepath.Join(root, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(fname), 0755)
+		if err != nil {
+			t.Fatalf("error creating dir for %s: %v", name, err)
+		}
+		err = ioutil.WriteFile(fname, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("error writing %s: %v", name, err)
+		}
+	}
+	ignore, err := blog.LoadIgnore(root, nil)
+	if err != nil {
+		t.Fatalf("error loading ignore file: %v", err)
+	}
+	return root, ignore
+}
+
+type walkedPage struct {
+	Bundle    blog.BundleType
+	Headless  bool
+	Resources []string
+}
+
+func TestWalk(t *testing.T) {
+	root, ignore := writeTree(t, bundleTree)
+	defer os.RemoveAll(root)
+
+	pages := make(map[string]walkedPage)
+	err := blog.Walk(root, ignore, func(page blog.Page, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, page.Path)
+		if err != nil {
+			return err
+		}
+		pages[filepath.ToSlash(rel)] = walkedPage{
+			Bundle:    page.Bundle,
+			Headless:  page.Headless,
+			Resources: page.Resources,
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error walking tree: %v", err)
+	}
+
+	leafResources := []string{"extra.md", "image.png", filepath.Join("sub", "data.json")}
+	want := map[string]walkedPage{
+		"post.md":                 {},
+		"leaf/index.md":           {Bundle: blog.LeafBundle, Resources: leafResources},
+		"leaf/index.fr.md":        {Bundle: blog.LeafBundle, Resources: leafResources},
+		"headless/index.md":       {Bundle: blog.LeafBundle, Headless: true},
+		"unrendered/index.md":     {Bundle: blog.LeafBundle, Headless: true},
+		"section/_index.md":       {Bundle: blog.BranchBundle, Resources: []string{"cover.jpg"}},
+		"section/child.md":        {},
+		"section/nested/index.md": {Bundle: blog.LeafBundle, Resources: []string{"resource.md", filepath.Join("sub", "deeper.md")}},
+		"both/index.md":           {Bundle: blog.LeafBundle, Resources: []string{"_index.md"}},
+	}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("wrong pages:\nwant=%+v,\n got=%+v", want, pages)
+	}
+}
+
+var isPageTestCases = [...]struct {
+	name string
+	page bool
+}{
+	0:  {name: "post.md", page: true},
+	1:  {name: "notes.txt"},
+	2:  {name: "drafts/wip.md"},
+	3:  {name: "leaf/index.md", page: true},
+	4:  {name: "leaf/index.fr.md", page: true},
+	5:  {name: "leaf/extra.md"},
+	6:  {name: "headless/index.md"},
+	7:  {name: "unrendered/index.md"},
+	8:  {name: "section/_index.md"},
+	9:  {name: "section/child.md", page: true},
+	10: {name: "section/nested/resource.md"},
+	11: {name: "section/nested/sub/deeper.md"},
+	12: {name: "both/index.md", page: true},
+}
+
+func TestIsPage(t *testing.T) {
+	root, ignore := writeTree(t, bundleTree)
+	defer os.RemoveAll(root)
+
+	for i, tc := range isPageTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			path := filepath.Join(root, filepath.FromSlash(tc.name))
+			if page := blog.IsPage(root, path, ignore); page != tc.page {
+				t.Errorf("wrong result for %s: want=%t, got=%t", tc.name, tc.page, page)
+			}
+		})
+	}
+}
+
+func TestReadPage(t *testing.T) {
+	root, ignore := writeTree(t, bundleTree)
+	defer os.RemoveAll(root)
+
+	page, err := blog.ReadPage(filepath.Join(root, "section", "_index.md"), ignore)
+	if err != nil {
+		t.Fatalf("error reading section page: %v", err)
+	}
+	if page.Bundle != blog.BranchBundle || page.Published() || !reflect.DeepEqual(page.Resources, []string{"cover.jpg"}) {
+		t.Errorf("wrong section page: %+v", page)
+	}
+
+	page, err = blog.ReadPage(filepath.Join(root, "headless", "index.md"), ignore)
+	if err != nil {
+		t.Fatalf("error reading headless page: %v", err)
+	}
+	if page.Bundle != blog.LeafBundle || !page.Headless || page.Published() {
+		t.Errorf("wrong headless page: %+v", page)
+	}
+
+	page, err = blog.ReadPage(filepath.Join(root, "post.md"), ignore)
+	if err != nil {
+		t.Fatalf("error reading regular page: %v", err)
+	}
+	if page.Bundle != blog.NoBundle || page.Info == nil || !page.Published() {
+		t.Errorf("wrong regular page: %+v", page)
+	}
+
+	_, err = blog.ReadPage(filepath.Join(root, "missing.md"), ignore)
+	if !os.IsNotExist(err) {
+		t.Errorf("wrong error reading missing page: want=%v, got=%v", os.ErrNotExist, err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the file in the root of the content tree that
+// contains patterns for files that should be ignored.
+const IgnoreFile = ".blogsyncignore"
+
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Ignore reports whether files in a content tree should be ignored.
+// A nil *Ignore does not ignore any files.
+type Ignore struct {
+	root     string
+	patterns []ignorePattern
+	files    []*regexp.Regexp
+}
+
+// LoadIgnore reads the IgnoreFile from the root of the content tree, if it
+// exists, and compiles the patterns it contains using the same syntax as
+// gitignore files.
+// Patterns are matched against paths relative to root.
+//
+// Each entry in ignoreFiles is a regular expression that is matched against
+// the full path of each file (including root), similar to the ignoreFiles
+// option in Hugo's config.
+func LoadIgnore(root string, ignoreFiles []string) (*Ignore, error) {
+	ig := &Ignore{root: filepath.Clean(root)}
+	for _, expr := range ignoreFiles {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling ignoreFiles pattern %q: %w", expr, err)
+		}
+		ig.files = append(ig.files, re)
+	}
+
+	fname := filepath.Join(root, IgnoreFile)
+	f, err := os.Open(fname)
+	switch {
+	case os.IsNotExist(err):
+		return ig, nil
+	case err != nil:
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	var lineNo int
+	for s.Scan() {
+		lineNo++
+		p, ok, err := compileIgnore(s.Text())
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fname, lineNo, err)
+		}
+		if ok {
+			ig.patterns = append(ig.patterns, p)
+		}
+	}
+	return ig, s.Err()
+}
+
+// Match reports whether the file or directory at path should be ignored.
+// The path should include the root of the content tree.
+// A file is also ignored if any of the directories containing it are ignored.
+func (ig *Ignore) Match(path string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+
+	path = filepath.Clean(path)
+	for _, re := range ig.files {
+		if re.MatchString(filepath.ToSlash(path)) {
+			return true
+		}
+	}
+
+	rel, err := filepath.Rel(ig.root, path)
+	if err != nil {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+
+	// Like git, once a directory is ignored nothing inside of it can be
+	// re-included.
+	for i, c := range rel {
+		if c == '/' && ig.matchRel(rel[:i], true) {
+			return true
+		}
+	}
+	return ig.matchRel(rel, isDir)
+}
+
+// matchRel checks the gitignore patterns against a path relative to the root,
+// the last pattern that matches wins.
+func (ig *Ignore) matchRel(rel string, isDir bool) bool {
+	var ignored bool
+	for _, p := range ig.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// compileIgnore converts a line from a gitignore style file into a regular
+// expression.
+// If the line is blank or a comment, ok will be false.
+func compileIgnore(line string) (p ignorePattern, ok bool, err error) {
+	// Trailing spaces are ignored unless they are escaped.
+	trimmed := strings.TrimRight(line, " \t")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		trimmed += " "
+	}
+	line = trimmed
+
+	switch {
+	case line == "", strings.HasPrefix(line, "#"):
+		return p, false, nil
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A pattern with a slash at the beginning or middle is relative to the root,
+	// otherwise it can match at any level.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return p, false, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/") && (i == 0 || line[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case line[i:] == "**" && (i == 0 || line[i-1] == '/'):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end == -1 {
+				b.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	p.re, err = regexp.Compile(b.String())
+	if err != nil {
+		return p, false, fmt.Errorf("invalid pattern %q: %w", line, err)
+	}
+	return p, true, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+var ignoreTestCases = [...]struct {
+	patterns string
+	files    []string
+	name     string
+	isDir    bool
+	ignored  bool
+}{
+	0:  {patterns: "*.md", name: "post.md", ignored: true},
+	1:  {patterns: "*.md", name: "a/b/post.md", ignored: true},
+	2:  {patterns: "*.md", name: "post.markdown"},
+	3:  {patterns: "# *.md", name: "post.md"},
+	4:  {patterns: `\#post.md`, name: "#post.md", ignored: true},
+	5:  {patterns: "drafts/", name: "drafts", isDir: true, ignored: true},
+	6:  {patterns: "drafts/", name: "drafts"},
+	7:  {patterns: "drafts/", name: "a/drafts/post.md", ignored: true},
+	8:  {patterns: "/drafts", name: "a/drafts/post.md"},
+	9:  {patterns: "a/*.md", name: "a/post.md", ignored: true},
+	10: {patterns: "a/*.md", name: "b/a/post.md"},
+	11: {patterns: "a/*.md", name: "a/b/post.md"},
+	12: {patterns: "a/**/post.md", name: "a/b/c/post.md", ignored: true},
+	13: {patterns: "a/**/post.md", name: "a/post.md", ignored: true},
+	14: {patterns: "**/post.md", name: "x/post.md", ignored: true},
+	15: {patterns: "a/**", name: "a/b/post.md", ignored: true},
+	16: {patterns: "post?.md", name: "post1.md", ignored: true},
+	17: {patterns: "post?.md", name: "post10.md"},
+	18: {patterns: "post[0-9].md", name: "post1.md", ignored: true},
+	19: {patterns: "post[!0-9].md", name: "post1.md"},
+	20: {patterns: "post[!0-9].md", name: "posta.md", ignored: true},
+	21: {patterns: "*.md\n!keep.md", name: "keep.md"},
+	22: {patterns: "*.md\n!keep.md", name: "drop.md", ignored: true},
+	// Files can't be re-included if their directory is ignored.
+	23: {patterns: "drafts/\n!drafts/keep.md", name: "drafts/keep.md", ignored: true},
+	24: {patterns: `trailing\ `, name: "trailing ", ignored: true},
+	25: {patterns: "trailing   ", name: "trailing", ignored: true},
+	26: {patterns: "[unclosed", name: "[unclosed", ignored: true},
+	27: {files: []string{`\.bak$`}, name: "post.md.bak", ignored: true},
+	28: {files: []string{`^content/`}, name: "post.md"},
+	29: {patterns: "*.md", name: "../outside.md"},
+}
+
+func TestIgnore(t *testing.T) {
+	for i, tc := range ignoreTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			root, _ := writeTree(t, map[string]string{blog.IgnoreFile: tc.patterns})
+			defer os.RemoveAll(root)
+			ignore, err := blog.LoadIgnore(root, tc.files)
+			if err != nil {
+				t.Fatalf("error loading patterns: %v", err)
+			}
+			path := filepath.Join(root, filepath.FromSlash(tc.name))
+			if ignored := ignore.Match(path, tc.isDir); ignored != tc.ignored {
+				t.Errorf("wrong result for %q: want=%t, got=%t", tc.name, tc.ignored, ignored)
+			}
+		})
+	}
+}
+
+func TestIgnoreErrors(t *testing.T) {
+	_, err := blog.LoadIgnore(os.TempDir(), []string{"("})
+	if err == nil || !strings.Contains(err.Error(), "ignoreFiles") {
+		t.Errorf("expected invalid ignoreFiles pattern to be reported, got %v", err)
+	}
+
+	var ignore *blog.Ignore
+	if ignore.Match("post.md", false) {
+		t.Errorf("expected nil Ignore not to match")
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rainycape/unidecode"
+)
+
+// Rules that may be used by a Slugger to normalize slugs.
+const (
+	// SlugWriteAs normalizes slugs the way write.as and WriteFreely do.
+	// Text in any script is transliterated to ASCII using the same tables as the
+	// server (so "Привет" becomes "privet" and "日本語" becomes "ri-ben-yu"), "&"
+	// becomes "and", the slug is lowercased, and any run of characters other than
+	// ASCII letters, digits, and underscores becomes a single "-".
+	// Quotes are dropped so that contractions read naturally; the server accepts
+	// the resulting slug unchanged.
+	// Characters that have no transliteration, such as emoji, are dropped.
+	SlugWriteAs = "writeas"
+
+	// SlugHugo normalizes slugs the way Hugo's urlize function does.
+	// Unicode letters, marks, digits, and the characters ".-_~+@" are kept as
+	// is, runs of whitespace between them become a single "-", and all other
+	// characters are dropped.
+	// Unlike urlize, path separators become "-" since a write.as slug cannot
+	// span several path segments, and "#" and "%" are dropped since they would
+	// change the meaning of the URL.
+	SlugHugo = "hugo"
+)
+
+// Slugger generates slugs for pages.
+// The zero value uses the SlugWriteAs rules.
+type Slugger struct {
+	// Rules is the set of rules used to normalize slugs, either SlugWriteAs or
+	// SlugHugo.
+	// Unrecognized values are treated as SlugWriteAs.
+	Rules string `toml:"Rules"`
+
+	// DisablePathToLower keeps the case of slugs generated by the SlugHugo
+	// rules.
+	DisablePathToLower bool `toml:"DisablePathToLower"`
+
+	// RemovePathAccents transliterates accented characters when using the
+	// SlugHugo rules.
+	// The SlugWriteAs rules always remove accents.
+	RemovePathAccents bool `toml:"RemovePathAccents"`
+}
+
+// Slug attempts to guess the slug that the final page will have using the
+// default rules.
+// For more information see Slugger.Slug.
+func Slug(filename string, meta Metadata) string {
+	return Slugger{}.Slug("", filename, meta, nil, nil)
+}
+
+// Slug attempts to guess the slug that the final page will have by checking if
+// the metadata has a "slug" attribute and, if not, generating one from the
+// permalink pattern for the pages section, the title, or the filename (the full
+// path should be passed so that trees such as "mypost/index.md" can be
+// recognized as a post called 'mypost' and not 'index').
+// The section of the page is the first directory in its path relative to root.
+//
+// Permalinks maps section names to patterns that may contain the tokens :year,
+// :month, :day, :title, :filename, and :section.
+// Dates in the page without a UTC offset are expanded in loc, or UTC if loc is
+// nil.
+func (s Slugger) Slug(root, filename string, meta Metadata, permalinks map[string]string, loc *time.Location) string {
+	// First see if the user has explicitly set a slug.
+	slug := meta.GetString("slug")
+
+	// If not, see if the pages section has a permalink pattern.
+	if slug == "" {
+		section := Section(root, filename)
+		if pattern, ok := permalinks[section]; ok {
+			slug = expandPermalink(pattern, section, filename, meta, loc)
+		}
+	}
+
+	// If not, use the post title.
+	if slug == "" {
+		slug = meta.GetString("title")
+	}
+
+	// Try to make sure the slug is actually valid as a slug, even if it comes
+	// from a title or path that won't necessarily be.
+	slug = s.Urlize(slug)
+
+	// If there is no post title, or nothing in it can be used in a slug, things
+	// will probably fail, but just in case try to guess the slug from the
+	// filename or path.
+	if slug == "" {
+		slug = s.Urlize(baseName(filename))
+	}
+	return slug
+}
+
+// Section returns the name of the section that the page at filename belongs
+// to, that is, the first directory in its path relative to root.
+// Pages at the root of the content tree have an empty section.
+func Section(root, filename string) string {
+	rel, err := filepath.Rel(root, filename)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	idx := strings.IndexByte(rel, '/')
+	if idx == -1 {
+		return ""
+	}
+	return rel[:idx]
+}
+
+// baseName extracts the name of a page from the filename or the last segment of
+// the path for bundles.
+func baseName(filename string) string {
+	var name string
+	base := filepath.Base(filename)
+	if base == "index.md" {
+		name = filepath.Base(filepath.Dir(filename))
+	}
+	if name == "" {
+		name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return name
+}
+
+// expandPermalink replaces the tokens in a permalink pattern with values from
+// the page.
+// The date tokens use the "date" field, falling back to "publishDate".
+// If the pattern contains date tokens and the page has neither, an empty string
+// is returned so that the slug is generated from the title instead.
+func expandPermalink(pattern, section, filename string, meta Metadata, loc *time.Location) string {
+	date := meta.GetTimeIn("date", loc)
+	if date.IsZero() {
+		date = meta.GetTimeIn("publishDate", loc)
+	}
+	if date.IsZero() && hasDateToken(pattern) {
+		return ""
+	}
+	title := meta.GetString("title")
+	name := baseName(filename)
+	if title == "" {
+		title = name
+	}
+	return strings.NewReplacer(
+		":year", date.Format("2006"),
+		":month", date.Format("01"),
+		":day", date.Format("02"),
+		":title", title,
+		":filename", name,
+		":section", section,
+	).Replace(pattern)
+}
+
+func hasDateToken(pattern string) bool {
+	return strings.Contains(pattern, ":year") || strings.Contains(pattern, ":month") || strings.Contains(pattern, ":day")
+}
+
+// Urlize normalizes str into a form suitable for use as a slug according to the
+// configured rules.
+func (s Slugger) Urlize(str string) string {
+	if s.Rules == SlugHugo {
+		return s.urlizeHugo(str)
+	}
+	return urlizeWriteAs(str)
+}
+
+// urlizeWriteAs normalizes str using the SlugWriteAs rules.
+func urlizeWriteAs(str string) string {
+	str = strings.Replace(str, "&", "and", -1)
+
+	var b strings.Builder
+	// pendingSep is set when a separator should be written before the next
+	// character that is kept, this lets us collapse runs of separators and drop
+	// leading and trailing separators entirely.
+	var pendingSep bool
+	for _, r := range unidecode.Unidecode(str) {
+		switch {
+		case isQuote(r):
+			// Quotes are dropped entirely so that contractions such as "c'est"
+			// become "cest" and not "c-est".
+			// Curly quotes have already been transliterated to ASCII quotes.
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if pendingSep && b.Len() > 0 {
+				b.WriteRune('-')
+			}
+			pendingSep = false
+			b.WriteRune(unicode.ToLower(r))
+		default:
+			pendingSep = true
+		}
+	}
+
+	// Like write.as, underscores are kept between words but not at either end.
+	return strings.Trim(b.String(), "_-")
+}
+
+// urlizeHugo normalizes str using the SlugHugo rules.
+func (s Slugger) urlizeHugo(str string) string {
+	var b strings.Builder
+	var pendingSep bool
+	write := func(r rune) {
+		if pendingSep {
+			b.WriteRune('-')
+		}
+		pendingSep = false
+		if !s.DisablePathToLower {
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	for _, r := range str {
+		if s.RemovePathAccents {
+			if t, ok := translitAccents[r]; ok {
+				for _, tr := range t {
+					write(tr)
+				}
+				continue
+			}
+			if unicode.Is(unicode.Mn, r) {
+				continue
+			}
+		}
+
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), unicode.IsMark(r):
+			write(r)
+		case r == '.', r == '_', r == '~', r == '+', r == '-', r == '@':
+			write(r)
+		case r == '/', r == '\\':
+			write('-')
+		case unicode.IsSpace(r) && b.Len() > 0:
+			// Like Hugo, leading and trailing whitespace is dropped and any run of
+			// whitespace between other characters becomes a single separator.
+			pendingSep = true
+		}
+	}
+
+	return b.String()
+}
+
+func isQuote(r rune) bool {
+	switch r {
+	case '\'', '"', '‘', '’', '“', '”', '`':
+		return true
+	}
+	return false
+}
+
+// translitAccents maps precomposed characters with diacritics to the letter
+// they are composed with, the same result as decomposing them and dropping the
+// combining marks.
+var translitAccents = makeTranslit([]struct{ from, to string }{
+	{"ÀÁÂÃÄÅĀĂĄ", "A"}, {"àáâãäåāăą", "a"},
+	{"ÇĆĈĊČ", "C"}, {"çćĉċč", "c"},
+	{"Ď", "D"}, {"ď", "d"},
+	{"ÈÉÊËĒĔĖĘĚ", "E"}, {"èéêëēĕėęě", "e"},
+	{"ĜĞĠĢ", "G"}, {"ĝğġģ", "g"},
+	{"Ĥ", "H"}, {"ĥ", "h"},
+	{"ÌÍÎÏĨĪĬĮİ", "I"}, {"ìíîïĩīĭį", "i"},
+	{"Ĵ", "J"}, {"ĵ", "j"},
+	{"Ķ", "K"}, {"ķ", "k"},
+	{"ĹĻĽ", "L"}, {"ĺļľ", "l"},
+	{"ÑŃŅŇ", "N"}, {"ñńņň", "n"},
+	{"ÒÓÔÕÖŌŎŐ", "O"}, {"òóôõöōŏő", "o"},
+	{"ŔŖŘ", "R"}, {"ŕŗř", "r"},
+	{"ŚŜŞŠ", "S"}, {"śŝşš", "s"},
+	{"ŢŤ", "T"}, {"ţť", "t"},
+	{"ÙÚÛÜŨŪŬŮŰŲ", "U"}, {"ùúûüũūŭůűų", "u"},
+	{"Ŵ", "W"}, {"ŵ", "w"},
+	{"ÝŶŸ", "Y"}, {"ýÿŷ", "y"},
+	{"ŹŻŽ", "Z"}, {"źżž", "z"},
+})
+
+func makeTranslit(table []struct{ from, to string }) map[rune]string {
+	m := make(map[rune]string)
+	for _, t := range table {
+		for _, r := range t.from {
+			m[r] = t.to
+		}
+	}
+	return m
+}
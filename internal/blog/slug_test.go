@@ -0,0 +1,146 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package blog_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+var urlizeTestCases = [...]struct {
+	slugger blog.Slugger
+	in      string
+	out     string
+}{
+	0:  {in: "Hello, World!", out: "hello-world"},
+	1:  {in: "Qu'est-ce que c'est? (Part 2)", out: "quest-ce-que-cest-part-2"},
+	2:  {in: "Crème brûlée", out: "creme-brulee"},
+	3:  {in: "Straße Œuvre Łódź", out: "strasse-oeuvre-lodz"},
+	4:  {in: "  --a  b--  ", out: "a-b"},
+	5:  {in: "2020/05/my post", out: "2020-05-my-post"},
+	6:  {in: "snake_case", out: "snake_case"},
+	7:  {in: "été", out: "ete"},
+	8:  {in: "日本語", out: "ri-ben-yu"},
+	9:  {in: "Привет, мир", out: "privet-mir"},
+	10: {in: "Γειά σου Κόσμε", out: "geia-sou-kosme"},
+	11: {in: "Über 日本語", out: "uber-ri-ben-yu"},
+	12: {in: "???", out: ""},
+	13: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "Vim (text editor)", out: "vim-text-editor"},
+	14: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "a - b", out: "a---b"},
+	15: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "  leading and trailing  ", out: "leading-and-trailing"},
+	16: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "a/b\\c", out: "a-b-c"},
+	17: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "v1.2_x~y+z@w#1%", out: "v1.2_x~y+z@w1"},
+	18: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "Crème Straße", out: "crème-straße"},
+	19: {slugger: blog.Slugger{Rules: blog.SlugHugo, RemovePathAccents: true}, in: "Crème Straße", out: "creme-straße"},
+	20: {slugger: blog.Slugger{Rules: blog.SlugHugo, DisablePathToLower: true}, in: "Hello World", out: "Hello-World"},
+	21: {slugger: blog.Slugger{Rules: blog.SlugHugo}, in: "日本語", out: "日本語"},
+	22: {in: "Tom & Jerry", out: "tom-and-jerry"},
+	23: {in: "R&D", out: "randd"},
+	24: {in: "_private_", out: "private"},
+	25: {in: "It’s “quoted”", out: "its-quoted"},
+	26: {in: "Cre\u0300me", out: "creme"},
+	27: {in: "🎉", out: ""},
+}
+
+func TestUrlize(t *testing.T) {
+	for i, tc := range urlizeTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out := tc.slugger.Urlize(tc.in)
+			if out != tc.out {
+				t.Errorf("wrong slug for %q: want=%q, got=%q", tc.in, tc.out, out)
+			}
+		})
+	}
+}
+
+var slugTestCases = [...]struct {
+	filename string
+	meta     blog.Metadata
+	slug     string
+}{
+	0: {filename: "content/post.md", meta: blog.Metadata{"title": "A Post"}, slug: "a-post"},
+	1: {filename: "content/post.md", meta: blog.Metadata{"title": "A Post", "slug": "Custom Slug"}, slug: "custom-slug"},
+	2: {filename: "content/my-post/index.md", meta: blog.Metadata{}, slug: "my-post"},
+	3: {filename: "content/post.md", meta: blog.Metadata{}, slug: "post"},
+	4: {filename: "content/fallback.md", meta: blog.Metadata{"title": "???"}, slug: "fallback"},
+}
+
+func TestSlug(t *testing.T) {
+	for i, tc := range slugTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			slug := blog.Slugger{}.Slug("content", tc.filename, tc.meta, nil, nil)
+			if slug != tc.slug {
+				t.Errorf("wrong slug for %s: want=%q, got=%q", tc.filename, tc.slug, slug)
+			}
+		})
+	}
+}
+
+var permalinkTestCases = [...]struct {
+	filename string
+	meta     blog.Metadata
+	pattern  string
+	slug     string
+}{
+	0: {
+		filename: "content/posts/first.md",
+		meta:     blog.Metadata{"title": "My Post", "date": "2020-05-02T10:00:00Z"},
+		pattern:  ":year-:month-:title",
+		slug:     "2020-05-my-post",
+	},
+	1: {
+		filename: "content/posts/first.md",
+		meta:     blog.Metadata{"title": "My Post", "publishDate": "2020-05-02"},
+		pattern:  ":year/:month/:day/:filename",
+		slug:     "2020-05-02-first",
+	},
+	2: {
+		filename: "content/posts/bundle/index.md",
+		meta:     blog.Metadata{"title": "My Post"},
+		pattern:  ":section-:filename",
+		slug:     "posts-bundle",
+	},
+	3: {
+		filename: "content/posts/first.md",
+		meta:     blog.Metadata{"title": "My Post"},
+		pattern:  ":year-:month-:title",
+		slug:     "my-post",
+	},
+	4: {
+		filename: "content/posts/first.md",
+		meta:     blog.Metadata{"title": "My Post", "slug": "explicit"},
+		pattern:  ":year-:title",
+		slug:     "explicit",
+	},
+	5: {
+		// Dates without an offset are in the site time zone and are not converted.
+		filename: "content/posts/first.md",
+		meta:     blog.Metadata{"title": "My Post", "date": "2020-05-31T23:00:00"},
+		pattern:  ":year-:month-:day",
+		slug:     "2020-05-31",
+	},
+	6: {
+		filename: "content/other/first.md",
+		meta:     blog.Metadata{"title": "My Post", "date": "2020-05-02"},
+		pattern:  ":year-:title",
+		slug:     "my-post",
+	},
+}
+
+func TestPermalinks(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	for i, tc := range permalinkTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			permalinks := map[string]string{"posts": tc.pattern}
+			slug := blog.Slugger{}.Slug("content", tc.filename, tc.meta, permalinks, loc)
+			if slug != tc.slug {
+				t.Errorf("wrong slug for %s: want=%q, got=%q", tc.filename, tc.slug, slug)
+			}
+		})
+	}
+}
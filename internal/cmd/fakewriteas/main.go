@@ -0,0 +1,64 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// The fakewriteas command serves an in-memory fake of the write.as API.
+//
+// It can be used to try out blogsync commands without changing a real blog:
+//
+//	go run ./internal/cmd/fakewriteas -addr 127.0.0.1:8081
+//
+// Then, in another terminal, use the URL and token that it prints:
+//
+//	WA_TOKEN=<token> blogsync --url http://127.0.0.1:8081/api publish
+//
+// Everything is lost when the command exits.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+
+	"mellium.im/blogsync/internal/writeastest"
+)
+
+func main() {
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+
+	var (
+		addr     = "127.0.0.1:0"
+		username = "blogsync"
+		password = "password"
+	)
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&addr, "addr", addr, "The address to listen on")
+	flags.StringVar(&username, "user", username, "The username of the account to create")
+	flags.StringVar(&password, "pass", password, "The password of the account to create")
+	/* #nosec */
+	flags.Parse(os.Args[1:])
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatalf("error listening on %s: %v", addr, err)
+	}
+	srv := writeastest.NewUnstartedServer()
+	// Replace the listener created by httptest which always uses a random port.
+	err = srv.Listener.Close()
+	if err != nil {
+		logger.Printf("error closing default listener: %v", err)
+	}
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	token := srv.AddUser(username, password)
+	logger.Printf("serving fake write.as API at %s", srv.APIURL())
+	logger.Printf("user %q (password %q) has the token %s", username, password, token)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	<-sigs
+}
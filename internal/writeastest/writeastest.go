@@ -0,0 +1,760 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+// Package writeastest provides an in-memory fake of the write.as API.
+//
+// The fake supports enough of the API to publish a site: logging in and out,
+// creating, updating, and deleting posts and collections, pinning posts, and
+// moving posts out of their collections.
+// Its state is only kept in memory and is lost when the server is closed.
+// It can be used in tests, or started with a fixed address and used as the
+// --url for blogsync commands to see what they would do without touching a
+// real server.
+package writeastest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rainycape/unidecode"
+)
+
+// APIPath is the path that the API is served under.
+const APIPath = "/api"
+
+// PostsPerPage is the number of posts returned for each page of a collection.
+const PostsPerPage = 10
+
+// Collection visibility values used by the API.
+const (
+	Unlisted = 0
+	Public   = 1
+	Private  = 2
+)
+
+// Post is a post stored by the fake server.
+type Post struct {
+	ID             string      `json:"id"`
+	Slug           string      `json:"slug,omitempty"`
+	Token          string      `json:"token,omitempty"`
+	Font           string      `json:"appearance"`
+	Language       *string     `json:"language,omitempty"`
+	RTL            *bool       `json:"rtl,omitempty"`
+	Created        time.Time   `json:"created"`
+	Updated        time.Time   `json:"updated"`
+	Title          string      `json:"title"`
+	Content        string      `json:"body"`
+	Views          int64       `json:"views"`
+	Tags           []string    `json:"tags"`
+	PinnedPosition *int        `json:"pinned_position,omitempty"`
+	Collection     *Collection `json:"collection,omitempty"`
+
+	owner string
+	alias string
+}
+
+// Collection is a collection stored by the fake server.
+type Collection struct {
+	Alias       string `json:"alias"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	StyleSheet  string `json:"style_sheet"`
+	Script      string `json:"script"`
+	Format      string `json:"format"`
+	Visibility  int    `json:"visibility"`
+	Public      bool   `json:"public"`
+	Private     bool   `json:"private"`
+	URL         string `json:"url"`
+	TotalPosts  int    `json:"total_posts"`
+	Views       int64  `json:"views"`
+	Posts       []Post `json:"posts,omitempty"`
+
+	owner string
+}
+
+// User is an account on the fake server.
+type User struct {
+	Username string    `json:"username"`
+	Email    string    `json:"email,omitempty"`
+	Created  time.Time `json:"created"`
+
+	password string
+}
+
+// Server is a fake write.as API server.
+type Server struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	users  map[string]*User
+	tokens map[string]string
+	posts  map[string]*Post
+	colls  map[string]*Collection
+	nextID int
+}
+
+// NewServer starts and returns a new fake server with no users.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a new fake server that has not been started.
+// This can be used to change the Listener, for example to listen on a fixed
+// address, before calling Start.
+func NewUnstartedServer() *Server {
+	s := &Server{
+		users:  make(map[string]*User),
+		tokens: make(map[string]string),
+		posts:  make(map[string]*Post),
+		colls:  make(map[string]*Collection),
+	}
+	s.Server = httptest.NewUnstartedServer(s)
+	return s
+}
+
+// APIURL returns the base URL of the API, suitable for passing to --url or
+// using in a go-writeas client config.
+func (s *Server) APIURL() string {
+	return s.URL + APIPath
+}
+
+// AddUser creates a user and returns an access token for them.
+func (s *Server) AddUser(username, password string) (token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[username] = &User{
+		Username: username,
+		Created:  time.Now().UTC(),
+		password: password,
+	}
+	return s.newToken(username)
+}
+
+// Posts returns a copy of every post on the server, oldest first.
+func (s *Server) Posts() []Post {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	posts := s.filterPosts(func(*Post) bool { return true })
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].Created.Before(posts[j].Created)
+	})
+	return posts
+}
+
+// Collections returns a copy of every collection on the server, sorted by
+// alias.
+func (s *Server) Collections() []Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	colls := make([]Collection, 0, len(s.colls))
+	for _, coll := range s.colls {
+		colls = append(colls, s.collectionJSON(coll))
+	}
+	sort.Slice(colls, func(i, j int) bool {
+		return colls[i].Alias < colls[j].Alias
+	})
+	return colls
+}
+
+// ServeHTTP implements the API.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, APIPath+"/") {
+		writeError(w, http.StatusNotFound, "Page not found.")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, APIPath), "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	username := s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")]
+
+	route := r.Method + " " + parts[0]
+	if len(parts) > 1 {
+		route += "/" + parts[1]
+	}
+	switch {
+	case route == "POST auth/login":
+		s.logIn(w, r)
+	case route == "DELETE auth/me":
+		s.logOut(w, r)
+	case parts[0] == "me":
+		if username == "" {
+			writeError(w, http.StatusUnauthorized, "Invalid access token.")
+			return
+		}
+		switch route {
+		case "GET me":
+			writeData(w, http.StatusOK, s.users[username])
+		case "GET me/posts":
+			posts := s.filterPosts(func(p *Post) bool { return p.owner == username })
+			sortPosts(posts, false)
+			writeData(w, http.StatusOK, posts)
+		case "GET me/collections":
+			colls := make([]Collection, 0)
+			for _, coll := range s.colls {
+				if coll.owner == username {
+					colls = append(colls, s.collectionJSON(coll))
+				}
+			}
+			sort.Slice(colls, func(i, j int) bool {
+				return colls[i].Alias < colls[j].Alias
+			})
+			writeData(w, http.StatusOK, colls)
+		default:
+			writeError(w, http.StatusNotFound, "Page not found.")
+		}
+	case parts[0] == "posts":
+		s.servePosts(w, r, username, parts[1:])
+	case parts[0] == "collections":
+		s.serveCollections(w, r, username, parts[1:])
+	default:
+		writeError(w, http.StatusNotFound, "Page not found.")
+	}
+}
+
+func (s *Server) logIn(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Alias string `json:"alias"`
+		Pass  string `json:"pass"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	user, ok := s.users[req.Alias]
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "User not found.")
+		return
+	case user.password != req.Pass:
+		writeError(w, http.StatusUnauthorized, "Incorrect password.")
+		return
+	}
+	writeData(w, http.StatusOK, struct {
+		AccessToken string `json:"access_token"`
+		User        *User  `json:"user"`
+	}{
+		AccessToken: s.newToken(user.Username),
+		User:        user,
+	})
+}
+
+func (s *Server) logOut(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Token ")
+	if _, ok := s.tokens[token]; !ok {
+		writeError(w, http.StatusNotFound, "Token is invalid.")
+		return
+	}
+	delete(s.tokens, token)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// postParams is the body of a request to create or update a post.
+type postParams struct {
+	Token    string     `json:"token"`
+	Title    *string    `json:"title"`
+	Content  *string    `json:"body"`
+	Font     *string    `json:"font"`
+	RTL      *bool      `json:"rtl"`
+	Language *string    `json:"lang"`
+	Created  *time.Time `json:"created"`
+	Updated  *time.Time `json:"updated"`
+	Slug     string     `json:"slug"`
+}
+
+func (s *Server) servePosts(w http.ResponseWriter, r *http.Request, username string, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+			return
+		}
+		s.createPost(w, r, username, nil)
+		return
+	}
+	if parts[0] == "disperse" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+			return
+		}
+		s.disperse(w, r, username)
+		return
+	}
+
+	post, ok := s.posts[parts[0]]
+	if !ok || len(parts) > 1 {
+		writeError(w, http.StatusNotFound, "Post not found.")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeData(w, http.StatusOK, s.postJSON(post, username))
+	case http.MethodPost:
+		var req postParams
+		if !decode(w, r, &req) {
+			return
+		}
+		if !canEdit(post, username, req.Token) {
+			writeError(w, http.StatusForbidden, "Invalid post token.")
+			return
+		}
+		applyPostParams(post, req)
+		if req.Updated == nil {
+			post.Updated = time.Now().UTC()
+		}
+		writeData(w, http.StatusOK, s.postJSON(post, username))
+	case http.MethodDelete:
+		if !canEdit(post, username, r.URL.Query().Get("token")) {
+			writeError(w, http.StatusForbidden, "Invalid post token.")
+			return
+		}
+		delete(s.posts, post.ID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+	}
+}
+
+// createPost creates a post from the request body, in coll if it is not nil.
+func (s *Server) createPost(w http.ResponseWriter, r *http.Request, username string, coll *Collection) {
+	var req postParams
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Content == nil || strings.TrimSpace(*req.Content) == "" {
+		writeError(w, http.StatusBadRequest, "Supply some text to publish.")
+		return
+	}
+	s.nextID++
+	now := time.Now().UTC()
+	post := &Post{
+		ID:      fmt.Sprintf("post%d", s.nextID),
+		Token:   randomToken(),
+		Font:    "norm",
+		Created: now,
+		Updated: now,
+		Tags:    []string{},
+		owner:   username,
+	}
+	applyPostParams(post, req)
+	if coll != nil {
+		post.alias = coll.Alias
+		// Posts that aren't in a collection don't have slugs.
+		post.Slug = s.uniqueSlug(coll.Alias, orDef(slugify(req.Slug), slugify(post.Title)), post.ID)
+	}
+	s.posts[post.ID] = post
+	writeData(w, http.StatusCreated, s.postJSON(post, username))
+}
+
+func applyPostParams(post *Post, req postParams) {
+	if req.Title != nil {
+		post.Title = *req.Title
+	}
+	if req.Content != nil {
+		post.Content = *req.Content
+	}
+	if req.Font != nil {
+		post.Font = *req.Font
+	}
+	if req.RTL != nil {
+		rtl := *req.RTL
+		post.RTL = &rtl
+	}
+	if req.Language != nil {
+		lang := *req.Language
+		post.Language = &lang
+	}
+	if req.Created != nil {
+		post.Created = req.Created.UTC()
+	}
+	if req.Updated != nil {
+		post.Updated = req.Updated.UTC()
+	}
+}
+
+func (s *Server) serveCollections(w http.ResponseWriter, r *http.Request, username string, parts []string) {
+	if len(parts) == 0 || parts[0] == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed.")
+			return
+		}
+		s.createCollection(w, r, username)
+		return
+	}
+
+	coll, ok := s.colls[parts[0]]
+	if !ok || (coll.Visibility == Private && coll.owner != username) {
+		writeError(w, http.StatusNotFound, "Collection not found.")
+		return
+	}
+	var sub string
+	if len(parts) > 1 {
+		sub = parts[1]
+	}
+	if r.Method != http.MethodGet && coll.owner != username {
+		writeError(w, http.StatusForbidden, "You don't own this collection.")
+		return
+	}
+
+	switch r.Method + " " + sub {
+	case "GET ":
+		writeData(w, http.StatusOK, s.collectionJSON(coll))
+	case "POST ":
+		s.updateCollection(w, r, coll)
+	case "DELETE ":
+		// Posts in deleted collections become drafts.
+		for _, post := range s.posts {
+			if post.alias == coll.Alias {
+				post.alias = ""
+				post.Slug = ""
+				post.PinnedPosition = nil
+			}
+		}
+		delete(s.colls, coll.Alias)
+		w.WriteHeader(http.StatusNoContent)
+	case "GET posts":
+		resp := s.collectionJSON(coll)
+		resp.Posts = s.filterPosts(func(p *Post) bool { return p.alias == coll.Alias })
+		sortPosts(resp.Posts, true)
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		start := (page - 1) * PostsPerPage
+		switch {
+		case start >= len(resp.Posts):
+			resp.Posts = []Post{}
+		case start+PostsPerPage < len(resp.Posts):
+			resp.Posts = resp.Posts[start : start+PostsPerPage]
+		default:
+			resp.Posts = resp.Posts[start:]
+		}
+		for i := range resp.Posts {
+			resp.Posts[i].Collection = nil
+		}
+		writeData(w, http.StatusOK, resp)
+	case "POST posts":
+		s.createPost(w, r, username, coll)
+	case "POST pin", "POST unpin":
+		s.pin(w, r, coll, sub == "pin")
+	default:
+		writeError(w, http.StatusNotFound, "Page not found.")
+	}
+}
+
+func (s *Server) createCollection(w http.ResponseWriter, r *http.Request, username string) {
+	if username == "" {
+		writeError(w, http.StatusUnauthorized, "Invalid access token.")
+		return
+	}
+	var req struct {
+		Alias       string `json:"alias"`
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	alias := orDef(slugify(req.Alias), slugify(req.Title))
+	switch {
+	case alias == "":
+		writeError(w, http.StatusBadRequest, "Parameter(s) 'alias' or 'title' required.")
+		return
+	case s.colls[alias] != nil:
+		writeError(w, http.StatusConflict, "Collection already exists.")
+		return
+	}
+	coll := &Collection{
+		Alias:       alias,
+		Title:       orDef(req.Title, alias),
+		Description: req.Description,
+		Format:      "blog",
+		Visibility:  Unlisted,
+		owner:       username,
+	}
+	s.colls[alias] = coll
+	writeData(w, http.StatusCreated, s.collectionJSON(coll))
+}
+
+func (s *Server) updateCollection(w http.ResponseWriter, r *http.Request, coll *Collection) {
+	var req struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		StyleSheet  *string `json:"style_sheet"`
+		Script      *string `json:"script"`
+		Format      *string `json:"format"`
+		Visibility  *int    `json:"visibility"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.Visibility != nil && (*req.Visibility < Unlisted || *req.Visibility > Private) {
+		writeError(w, http.StatusBadRequest, "Invalid visibility.")
+		return
+	}
+	for _, f := range []struct {
+		dst *string
+		src *string
+	}{
+		{&coll.Title, req.Title},
+		{&coll.Description, req.Description},
+		{&coll.StyleSheet, req.StyleSheet},
+		{&coll.Script, req.Script},
+		{&coll.Format, req.Format},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
+		}
+	}
+	if req.Visibility != nil {
+		coll.Visibility = *req.Visibility
+	}
+	writeData(w, http.StatusOK, s.collectionJSON(coll))
+}
+
+func (s *Server) pin(w http.ResponseWriter, r *http.Request, coll *Collection, pin bool) {
+	var req []struct {
+		ID       string `json:"id"`
+		Position int    `json:"position"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	type result struct {
+		ID      string `json:"id"`
+		Code    int    `json:"code"`
+		Message string `json:"error_msg,omitempty"`
+	}
+	results := make([]result, 0, len(req))
+	for _, p := range req {
+		post, ok := s.posts[p.ID]
+		if !ok || post.alias != coll.Alias {
+			results = append(results, result{ID: p.ID, Code: http.StatusNotFound, Message: "Post not found."})
+			continue
+		}
+		if !pin {
+			post.PinnedPosition = nil
+			results = append(results, result{ID: p.ID, Code: http.StatusOK})
+			continue
+		}
+		pos := p.Position
+		if pos <= 0 {
+			// Pin after any other pinned posts.
+			for _, other := range s.posts {
+				if other.alias == coll.Alias && other.PinnedPosition != nil && *other.PinnedPosition >= pos {
+					pos = *other.PinnedPosition + 1
+				}
+			}
+			if pos <= 0 {
+				pos = 1
+			}
+		}
+		post.PinnedPosition = &pos
+		results = append(results, result{ID: p.ID, Code: http.StatusOK})
+	}
+	writeData(w, http.StatusOK, results)
+}
+
+// disperse moves the posts with the IDs in the request body out of their
+// collections, leaving them as unlisted posts.
+func (s *Server) disperse(w http.ResponseWriter, r *http.Request, username string) {
+	if username == "" {
+		writeError(w, http.StatusUnauthorized, "Invalid access token.")
+		return
+	}
+	var req []string
+	if !decode(w, r, &req) {
+		return
+	}
+	type result struct {
+		ID      string `json:"id"`
+		Code    int    `json:"code"`
+		Message string `json:"error_msg,omitempty"`
+		Post    *Post  `json:"post,omitempty"`
+	}
+	results := make([]result, 0, len(req))
+	for _, id := range req {
+		post, ok := s.posts[id]
+		if !ok || post.owner != username {
+			results = append(results, result{ID: id, Code: http.StatusNotFound, Message: "Post not found."})
+			continue
+		}
+		post.alias = ""
+		post.Slug = ""
+		post.PinnedPosition = nil
+		p := s.postJSON(post, username)
+		results = append(results, result{ID: id, Code: http.StatusOK, Post: &p})
+	}
+	writeData(w, http.StatusOK, results)
+}
+
+// postJSON returns a copy of post as it should be shown to username.
+func (s *Server) postJSON(post *Post, username string) Post {
+	p := *post
+	if post.owner == "" || post.owner != username {
+		p.Token = ""
+	}
+	if coll, ok := s.colls[post.alias]; ok {
+		c := s.collectionJSON(coll)
+		p.Collection = &c
+	}
+	return p
+}
+
+func (s *Server) collectionJSON(coll *Collection) Collection {
+	c := *coll
+	c.Public = c.Visibility == Public
+	c.Private = c.Visibility == Private
+	c.URL = s.URL + "/" + c.Alias + "/"
+	c.TotalPosts = 0
+	for _, post := range s.posts {
+		if post.alias == c.Alias {
+			c.TotalPosts++
+		}
+	}
+	return c
+}
+
+// filterPosts returns a copy of every post for which f returns true, including
+// tokens.
+func (s *Server) filterPosts(f func(*Post) bool) []Post {
+	posts := make([]Post, 0)
+	for _, post := range s.posts {
+		if f(post) {
+			posts = append(posts, s.postJSON(post, post.owner))
+		}
+	}
+	return posts
+}
+
+// uniqueSlug returns slug, or slug with a number added to it if another post
+// in the collection already uses it.
+func (s *Server) uniqueSlug(alias, slug, id string) string {
+	if slug == "" {
+		slug = id
+	}
+	taken := func(slug string) bool {
+		for _, post := range s.posts {
+			if post.alias == alias && post.Slug == slug {
+				return true
+			}
+		}
+		return false
+	}
+	candidate := slug
+	for i := 2; taken(candidate); i++ {
+		candidate = slug + "-" + strconv.Itoa(i)
+	}
+	return candidate
+}
+
+func (s *Server) newToken(username string) string {
+	token := randomToken()
+	s.tokens[token] = username
+	return token
+}
+
+// sortPosts sorts posts by date, newest first, optionally with pinned posts
+// first.
+func sortPosts(posts []Post, pinnedFirst bool) {
+	sort.SliceStable(posts, func(i, j int) bool {
+		pi, pj := posts[i].PinnedPosition, posts[j].PinnedPosition
+		switch {
+		case pinnedFirst && pi != nil && pj != nil && *pi != *pj:
+			return *pi < *pj
+		case pinnedFirst && (pi != nil) != (pj != nil):
+			return pi != nil
+		case !posts[i].Created.Equal(posts[j].Created):
+			return posts[i].Created.After(posts[j].Created)
+		}
+		return posts[i].ID < posts[j].ID
+	})
+}
+
+func canEdit(post *Post, username, token string) bool {
+	return (post.owner != "" && post.owner == username) || (token != "" && token == post.Token)
+}
+
+var (
+	slugUnauthorized = regexp.MustCompile("[^a-z0-9-_]")
+	slugDashes       = regexp.MustCompile("-+")
+)
+
+// slugify normalizes s the same way that write.as does when it generates a
+// slug or collection alias.
+// This is a copy of the default rules from github.com/writeas/slug and is kept
+// separate from the slug rules in blogsync so that tests notice when the two
+// disagree.
+func slugify(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.Replace(s, "&", "and", -1)
+	s = strings.ToLower(unidecode.Unidecode(s))
+	s = slugUnauthorized.ReplaceAllString(s, "-")
+	s = slugDashes.ReplaceAllString(s, "-")
+	return strings.Trim(s, "-_")
+}
+
+func orDef(val, def string) string {
+	if val == "" {
+		return def
+	}
+	return val
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Errorf("error generating token: %w", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// decode decodes the JSON request body into v, writing an error response and
+// returning false if it is invalid.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Expected valid JSON object.")
+		return false
+	}
+	return true
+}
+
+func writeData(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	/* #nosec */
+	json.NewEncoder(w).Encode(struct {
+		Code int         `json:"code"`
+		Data interface{} `json:"data"`
+	}{
+		Code: code,
+		Data: v,
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	/* #nosec */
+	json.NewEncoder(w).Encode(struct {
+		Code int    `json:"code"`
+		Msg  string `json:"error_msg"`
+	}{
+		Code: code,
+		Msg:  msg,
+	})
+}
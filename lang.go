@@ -0,0 +1,213 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+const defContentLanguage = "en"
+
+// LanguageConfig holds the config for a single language on a multilingual
+// site.
+// Any fields that are not set use the value from the main site config.
+type LanguageConfig struct {
+	Collection   string `toml:"Collection"`
+	ContentDir   string `toml:"ContentDir"`
+	Description  string `toml:"Description"`
+	LanguageName string `toml:"LanguageName"`
+	Title        string `toml:"Title"`
+	Weight       int    `toml:"Weight"`
+
+	Params map[string]interface{} `toml:"Params"`
+}
+
+// langSite is the options and config used to publish the content for a
+// single language.
+type langSite struct {
+	opts   publishOptions
+	config Config
+}
+
+// translation is another language version of a page, passed to templates.
+type translation struct {
+	Lang         string
+	LanguageName string
+	Title        string
+	Collection   string
+	Slug         string
+}
+
+// languageSites returns the options and config used to publish each language
+// configured in the languages table of the site config, ordered by weight.
+// If no languages are configured, the original options and config are used to
+// publish a single site.
+func languageSites(opts publishOptions, siteConfig Config) ([]langSite, error) {
+	if len(siteConfig.Languages) == 0 {
+		var err error
+		opts.ignore, err = blog.LoadIgnore(opts.content, siteConfig.IgnoreFiles)
+		if err != nil {
+			return nil, fmt.Errorf("error loading ignore rules: %w", err)
+		}
+		return []langSite{{opts: opts, config: siteConfig}}, nil
+	}
+
+	codes := make([]string, 0, len(siteConfig.Languages))
+	for code := range siteConfig.Languages {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		wi, wj := siteConfig.Languages[codes[i]].Weight, siteConfig.Languages[codes[j]].Weight
+		if wi != wj {
+			return wi < wj
+		}
+		return codes[i] < codes[j]
+	})
+	defLang := orDef(siteConfig.DefaultContentLanguage, defContentLanguage)
+	if _, ok := siteConfig.Languages[defLang]; !ok {
+		defLang = codes[0]
+	}
+
+	contentDir := func(code string) string {
+		return filepath.Clean(orDef(siteConfig.Languages[code].ContentDir, opts.content))
+	}
+
+	sites := make([]langSite, 0, len(codes))
+	for _, code := range codes {
+		lang := siteConfig.Languages[code]
+		langOpts := opts
+		langOpts.lang = code
+		langOpts.languages = siteConfig.Languages
+		langOpts.content = contentDir(code)
+
+		// Pages in a content directory shared by multiple languages belong to the
+		// default language unless their filename says otherwise (eg.
+		// "about.fr.md"), or to the first language using the directory if it is
+		// not shared with the default language.
+		langOpts.unmarkedLang = code
+		if contentDir(defLang) == langOpts.content {
+			langOpts.unmarkedLang = defLang
+		} else {
+			for _, other := range codes {
+				if contentDir(other) == langOpts.content {
+					langOpts.unmarkedLang = other
+					break
+				}
+			}
+		}
+
+		langOpts.collection = lang.Collection
+		if langOpts.collection == "" && opts.collection != "" {
+			langOpts.collection = opts.collection
+			if code != defLang {
+				langOpts.collection += "-" + code
+			}
+		}
+
+		var err error
+		langOpts.ignore, err = blog.LoadIgnore(langOpts.content, siteConfig.IgnoreFiles)
+		if err != nil {
+			return nil, fmt.Errorf("error loading ignore rules for language %s: %w", code, err)
+		}
+
+		langConfig := siteConfig
+		langConfig.Language = code
+		langConfig.Collection = langOpts.collection
+		langConfig.Content = langOpts.content
+		langConfig.Title = orDef(lang.Title, siteConfig.Title)
+		langConfig.Description = orDef(lang.Description, siteConfig.Description)
+		if len(lang.Params) > 0 {
+			langConfig.Params = make(map[string]interface{}, len(siteConfig.Params)+len(lang.Params))
+			for k, v := range siteConfig.Params {
+				langConfig.Params[k] = v
+			}
+			for k, v := range lang.Params {
+				langConfig.Params[k] = v
+			}
+		}
+
+		sites = append(sites, langSite{opts: langOpts, config: langConfig})
+	}
+	return sites, nil
+}
+
+// siteFor returns the language site that publishes the page at pagePath.
+func siteFor(sites []langSite, pagePath string) (langSite, bool) {
+	pagePath = filepath.Clean(pagePath)
+	for _, site := range sites {
+		rel, err := filepath.Rel(site.opts.content, pagePath)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if site.opts.includes(pagePath) {
+			return site, true
+		}
+	}
+	return langSite{}, false
+}
+
+// walkPages walks the pages in the content directory that belong to the
+// language being published.
+func (opts publishOptions) walkPages(walkFn filepath.WalkFunc) error {
+	return blog.WalkPages(opts.content, opts.ignore, func(pagePath string, info os.FileInfo, err error) error {
+		if err == nil && !opts.includes(pagePath) {
+			return nil
+		}
+		return walkFn(pagePath, info, err)
+	})
+}
+
+// includes reports whether the page at pagePath belongs to the language being
+// published.
+func (opts publishOptions) includes(pagePath string) bool {
+	lang := fileLang(pagePath, opts.languages)
+	if lang == "" {
+		lang = opts.unmarkedLang
+	}
+	return lang == opts.lang
+}
+
+// fileLang returns the language code in a filename such as "about.fr.md" or
+// "index.fr.md", or the empty string if the filename does not contain one of
+// the configured languages.
+func fileLang(pagePath string, languages map[string]LanguageConfig) string {
+	base := filepath.Base(pagePath)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	lang := strings.TrimPrefix(filepath.Ext(base), ".")
+	if _, ok := languages[lang]; !ok {
+		return ""
+	}
+	return lang
+}
+
+// translationKey returns the key used to find other translations of a page.
+// If the page does not set "translationKey" in its frontmatter, the path of
+// the page relative to the content directory without any language code or
+// extension is used, similar to Hugo.
+func translationKey(opts publishOptions, pagePath string, meta blog.Metadata) string {
+	if key := meta.GetString("translationKey"); key != "" {
+		return key
+	}
+
+	rel, err := filepath.Rel(opts.content, pagePath)
+	if err != nil {
+		rel = pagePath
+	}
+	rel = filepath.ToSlash(rel)
+	base := strings.TrimSuffix(rel, filepath.Ext(rel))
+	if lang := fileLang(pagePath, opts.languages); lang != "" {
+		base = strings.TrimSuffix(base, "."+lang)
+	}
+	if dir, file := filepath.Split(base); file == "index" {
+		base = strings.TrimSuffix(dir, "/")
+	}
+	return base
+}
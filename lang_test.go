@@ -0,0 +1,66 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+var testLanguages = map[string]LanguageConfig{
+	"en": {},
+	"fr": {},
+}
+
+var fileLangTestCases = [...]struct {
+	path string
+	lang string
+}{
+	0: {path: "about.md"},
+	1: {path: "about.fr.md", lang: "fr"},
+	2: {path: "posts/index.fr.md", lang: "fr"},
+	3: {path: "about.de.md"},
+	4: {path: "v1.2.md"},
+	5: {path: "fr.md"},
+	6: {path: "about.en.markdown", lang: "en"},
+}
+
+func TestFileLang(t *testing.T) {
+	for i, tc := range fileLangTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if lang := fileLang(filepath.FromSlash(tc.path), testLanguages); lang != tc.lang {
+				t.Errorf("wrong language for %s: want=%q, got=%q", tc.path, tc.lang, lang)
+			}
+		})
+	}
+}
+
+var translationKeyTestCases = [...]struct {
+	path string
+	meta blog.Metadata
+	key  string
+}{
+	0: {path: "content/about.md", key: "about"},
+	1: {path: "content/about.fr.md", key: "about"},
+	2: {path: "content/posts/hello.en.md", key: "posts/hello"},
+	3: {path: "content/posts/hello/index.fr.md", key: "posts/hello"},
+	4: {path: "content/posts/hello/index.md", key: "posts/hello"},
+	5: {path: "content/about.de.md", key: "about.de"},
+	6: {path: "content/about.fr.md", meta: blog.Metadata{"translationKey": "a"}, key: "a"},
+}
+
+func TestTranslationKey(t *testing.T) {
+	opts := publishOptions{content: "content", languages: testLanguages}
+	for i, tc := range translationKeyTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if key := translationKey(opts, filepath.FromSlash(tc.path), tc.meta); key != tc.key {
+				t.Errorf("wrong translation key for %s: want=%q, got=%q", tc.path, tc.key, key)
+			}
+		})
+	}
+}
@@ -10,13 +10,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/writeas/go-writeas/v2"
 	"mellium.im/cli"
 )
@@ -32,13 +33,15 @@ const (
 
 // Config holds site configuration.
 type Config struct {
-	BaseURL     string `toml:"BaseURL"`
-	Collection  string `toml:"Collection"`
-	Content     string `toml:"Content"`
-	Description string `toml:"Description"`
-	Language    string `toml:"Language"`
-	Title       string `toml:"Title"`
-	Tmpl        string `toml:"Tmpl"`
+	BaseURL      string `toml:"BaseURL"`
+	Collection   string `toml:"Collection"`
+	Content      string `toml:"Content"`
+	Description  string `toml:"Description"`
+	ExpiryPolicy string `toml:"ExpiryPolicy"`
+	Language     string `toml:"Language"`
+	Title        string `toml:"Title"`
+	TimeZone     string `toml:"TimeZone"`
+	Tmpl         string `toml:"Tmpl"`
 
 	Author []struct {
 		Name  string `toml:"Name"`
@@ -46,7 +49,22 @@ type Config struct {
 		URI   string `toml:"URI"`
 	} `toml:"Author"`
 
-	Params map[string]interface{} `toml:"Params"`
+	Params      map[string]interface{} `toml:"Params"`
+	Permalinks  map[string]string      `toml:"Permalinks"`
+	Slugs       SlugConfig             `toml:"Slugs"`
+	IgnoreFiles []string               `toml:"IgnoreFiles"`
+
+	DefaultContentLanguage string                    `toml:"DefaultContentLanguage"`
+	Languages              map[string]LanguageConfig `toml:"Languages"`
+
+	Targets     []Target                    `toml:"Targets"`
+	Collections map[string]CollectionConfig `toml:"Collections"`
+	Preview     PreviewConfig               `toml:"Preview"`
+
+	location *time.Location
+	// sources are the files and directories that the config was, or would have
+	// been, loaded from.
+	sources []string
 }
 
 func main() {
@@ -60,13 +78,15 @@ func main() {
 		torPort = intEnv(envTorPort)
 		apiBase = envOrDef(envAPIBase, "https://write.as/api")
 		config  = ""
+		env     = envOrDef(envEnvironment, defEnvironment)
 	)
 	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	flags.Usage = func() {}
 	flags.BoolVar(&verbose, "v", false, "Enables verbose debug logging")
 	flags.IntVar(&torPort, "orport", torPort, "The port of a local Tor SOCKS proxy, overrides $"+envTorPort)
 	flags.StringVar(&apiBase, "url", apiBase, "The base API URL, overrides $"+envAPIBase)
-	flags.StringVar(&config, "config", config, `The config file to load (defaults to "config.toml"`)
+	flags.StringVar(&config, "config", config, `A comma separated list of config files to load (defaults to "hugo.toml" or "config.toml")`)
+	flags.StringVar(&env, "environment", env, "The environment to load config overrides from in the config directory, overrides $"+envEnvironment)
 
 	// Parse flags and perform setup based on global flags such as enabling
 	// verbose logging and creating a write.as client.
@@ -85,22 +105,22 @@ func main() {
 		debug.SetOutput(os.Stderr)
 	}
 
-	siteConfig := Config{}
-	cfgFile := config
-	if cfgFile == "" {
-		cfgFile = "config.toml"
-	}
-	_, err = toml.DecodeFile(cfgFile, &siteConfig)
-	if err != nil && config != "" {
-		logger.Fatalf("error loading %s: %v", cfgFile, err)
+	// Commands that don't need a site (eg. token) still work if no config file
+	// is found, but the ones that do fail with configErr when they are run.
+	siteConfig, configErr := loadConfig(config, env, logger, debug)
+	switch {
+	case errors.Is(configErr, errNoConfig) && config == "":
+		debug.Printf("%v", configErr)
+	case configErr != nil:
+		logger.Fatalf("error loading config: %v", configErr)
 	}
 
-	_, tok := loadUser(debug)
-	client := writeas.NewClientWith(writeas.Config{
+	_, tok := loadUser(apiBase, debug)
+	clientConfig := writeas.Config{
 		URL:     apiBase,
 		Token:   tok,
 		TorPort: torPort,
-	})
+	}
 
 	// Setup the CLI
 	cmds := &cli.Command{
@@ -112,10 +132,13 @@ To get a token, use the "token" command.`, os.Args[0], userConfig, envToken),
 		Flags: flags,
 		Commands: []*cli.Command{
 			// Sub-commands
-			collectionsCmd(client, logger, debug),
-			convertCmd(logger, debug),
-			previewCmd(siteConfig, logger, debug),
-			publishCmd(siteConfig, client, logger, debug),
+			collectionsCmd(siteConfig, clientConfig, torPort, logger, debug),
+			requireConfig(convertCmd(siteConfig, logger, debug), configErr),
+			requireConfig(previewCmd(siteConfig, func() (Config, error) {
+				return loadConfig(config, env, logger, debug)
+			}, logger, debug), configErr),
+			requireConfig(publishCmd(siteConfig, clientConfig, torPort, logger, debug), configErr),
+			requireConfig(scheduleCmd(siteConfig, clientConfig, torPort, logger, debug), configErr),
 			tokenCmd(apiBase, torPort, logger, debug),
 
 			// Help articles
@@ -164,3 +187,15 @@ To get a token, use the "token" command.`, os.Args[0], userConfig, envToken),
 		os.Exit(4)
 	}
 }
+
+// requireConfig replaces the Run function of cmd with one that returns an error
+// if err (the result of loading the site config) is not nil.
+func requireConfig(cmd *cli.Command, err error) *cli.Command {
+	if err == nil {
+		return cmd
+	}
+	cmd.Run = func(*cli.Command, ...string) error {
+		return fmt.Errorf("%w, run %s from the root of a Hugo site or set --config", err, cmd.Name())
+	}
+	return cmd
+}
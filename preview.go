@@ -5,17 +5,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
@@ -37,6 +47,7 @@ const (
 
 type writeFreelyConfig struct {
 	Bind            string
+	Host            string
 	Collection      string
 	DBFile          string
 	Port            int
@@ -71,7 +82,7 @@ port     = 3306
 [app]
 site_name          = {{.SiteName}}
 site_description   = {{.SiteDescription}}
-host               = http://{{.Bind}}:{{.Port}}
+host               = {{.Host}}
 theme              = write
 editor             =
 disable_js         = false
@@ -93,137 +104,292 @@ user_invites       =
 default_visibility = public
 `
 
-func previewCmd(siteConfig Config, logger, debug *log.Logger) *cli.Command {
+// managedCfgTmpl contains the settings that blogsync needs to control for
+// preview to work.
+// They are applied after any base config and overrides from the site config.
+const managedCfgTmpl = `
+[server]
+port            = {{.Port}}
+bind            = {{.Bind}}
+keys_parent_dir = {{.Prefix}}
+
+[database]
+type     = sqlite3
+filename = {{.Prefix}}/{{.DBFile}}
+
+[app]
+host = {{.Host}}
+`
+
+// PreviewConfig configures the writefreely instance used by the preview
+// command.
+type PreviewConfig struct {
+	// BaseConfig is an existing writefreely config file to use in place of the
+	// default settings.
+	// Any settings that it does not contain are filled in with the defaults.
+	BaseConfig string `toml:"BaseConfig"`
+
+	// App and Server override keys in the [app] and [server] sections of the
+	// writefreely config.
+	App    map[string]interface{} `toml:"App"`
+	Server map[string]interface{} `toml:"Server"`
+}
+
+// previewBackend is a server that pages are published to while previewing.
+type previewBackend interface {
+	// baseURL returns the URL that the preview can be viewed at.
+	baseURL() string
+
+	// publishPage publishes the page at pagePath, updating the post previously
+	// published from it if there is one, and returns the URL of the post, or an
+	// empty string if the page was skipped.
+	publishPage(pagePath string, site langSite) (string, error)
+
+	// removePage removes the post published from the page at pagePath, if any.
+	removePage(pagePath string) error
+
+	// republish replaces every post with the pages in opts rendered using the
+	// given template and site config.
+	republish(opts publishOptions, siteConfig Config) error
+
+	// pagePaths returns the paths of all pages that are currently published.
+	pagePaths() []string
+}
+
+// previewCmd serves a preview of the site.
+// When the config or template changes, the config is reloaded using
+// loadSiteConfig and every page is published again.
+func previewCmd(siteConfig Config, loadSiteConfig func() (Config, error), logger, debug *log.Logger) *cli.Command {
 	opts := newPublishOpts(siteConfig)
 	opts.createCollections = true
+	opts.future = false
+	opts.expired = false
+	opts.banners = true
 
 	var (
-		port = 8080
-		bind = "127.0.0.1"
-		res  = "/usr/share/writefreely/"
+		port        = 8080
+		bind        = "127.0.0.1"
+		writeFreely = false
+		wfOpts      = writeFreelyOptions{
+			resources: "/usr/share/writefreely/",
+			timeout:   30 * time.Second,
+		}
 	)
 	flags := flag.NewFlagSet("preview", flag.ContinueOnError)
-	flags.IntVar(&port, "port", port, "The port for writefreely to bind to")
+	flags.IntVar(&port, "port", port, "The port for the preview server to bind to, or 0 to pick a free port")
 	flags.StringVar(&bind, "addr", bind, "The address the server should bind to")
 	flags.StringVar(&opts.content, "content", opts.content, "A directory containing pages and posts")
-	flags.StringVar(&res, "resources", res, "A directory containing writefreelys templates and static assets")
+	flags.BoolVar(&opts.drafts, "drafts", opts.drafts, "Include pages marked as drafts")
+	flags.BoolVar(&opts.future, "future", opts.future, "Include pages with a publish date in the future")
+	flags.BoolVar(&opts.expired, "expired", opts.expired, "Include pages with an expiry date in the past")
+	flags.BoolVar(&writeFreely, "writefreely", writeFreely, "Preview using a local install of writefreely instead of the built in server")
+	flags.StringVar(&wfOpts.resources, "resources", wfOpts.resources, "A directory containing writefreelys templates and static assets")
+	flags.DurationVar(&wfOpts.timeout, "timeout", wfOpts.timeout, "With --writefreely, how long to wait for writefreely to start")
+	flags.StringVar(&wfOpts.dataDir, "data-dir", wfOpts.dataDir, "With --writefreely, a directory to keep the database, keys, and config in between runs (defaults to a temporary directory)")
 
 	return &cli.Command{
-		Usage:       "preview [options]",
-		Flags:       flags,
-		Description: `Launch writefreely and upload current pages.`,
+		Usage: "preview [options]",
+		Flags: flags,
+		Description: `Serve a preview of the current pages.
+
+By default pages are rendered using the same templates as the publish command
+and served by a built in server that mimics the layout of write.as.
+With --writefreely, writefreely is launched instead and the pages are uploaded
+to it.
+The writefreely config can be changed using the [Preview] section of the site
+config.
+Normally writefreely is setup from scratch in a temporary directory each time.
+If --data-dir is set, the database, keys, and config are kept in that directory
+instead and on the next run only pages that have changed are uploaded.
+
+Like Hugo, drafts, pages with a publish date in the future, and pages that have
+expired are not shown unless the --drafts, --future, or --expired options are
+given.
+Pages with a publish date in the future are shown once that date arrives.
+When they are shown, a notice is added to the top of the post.
+
+Changes to pages are published as they are saved.
+Changes to the config files, the template file, or the ignore rules in
+.blogsyncignore cause every page to be published again.`,
 		Run: func(cmd *cli.Command, args ...string) error {
 			// Override the default SIGINT handler so that we can cleanup properly on
 			// Ctrl+C instead of immediately exiting.
 			sigs := make(chan os.Signal, 1)
 			signal.Notify(sigs, os.Interrupt)
 
-			_, err := exec.LookPath(binName)
-			if err != nil {
-				return fmt.Errorf(`
-The 'writefreely' command could not be found.
-To use the preview feature, please install writefreely:
-
-https://writefreely.org/
-
-(original error: %w)`, err)
-			}
-
-			tmpDir, err := mkTmp(writeFreelyConfig{
-				Bind:            bind,
-				Collection:      siteConfig.Collection,
-				DBFile:          dbFileName,
-				Port:            port,
-				Resources:       res,
-				SiteDescription: siteConfig.Description,
-				SiteName:        siteConfig.Title,
-			}, debug)
-			if err != nil {
-				return fmt.Errorf("can't create temporary directories: %v", err)
-			}
-			defer func() {
-				err := os.RemoveAll(tmpDir)
-				if err != nil {
-					debug.Printf("error removing temporary dir %s: %v", tmpDir, err)
-				}
-			}()
-
-			var cfgFilePath = filepath.Join(tmpDir, cfgFileName)
-
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
-			err = tailWriteFreely(ctx, cfgFilePath, debug, "-gen-keys")
+			sites, err := languageSites(opts, siteConfig)
 			if err != nil {
 				return err
 			}
-			err = tailWriteFreely(ctx, cfgFilePath, debug, "-init-db")
-			if err != nil {
-				return err
+			var contentFlag bool
+			flags.Visit(func(f *flag.Flag) {
+				contentFlag = contentFlag || f.Name == "content"
+			})
+
+			addr := net.JoinHostPort(bind, strconv.Itoa(port))
+			reload := newLiveReload()
+			var backend previewBackend
+			if writeFreely {
+				wfOpts.addr = addr
+				wf, err := startWriteFreely(ctx, cancel, wfOpts, reload, opts, siteConfig, logger, debug)
+				if err != nil {
+					return err
+				}
+				defer wf.close()
+				backend = wf
+			} else {
+				srv, err := startPreviewServer(ctx, addr, reload, opts, siteConfig, logger, debug)
+				if err != nil {
+					return err
+				}
+				backend = srv
 			}
-			err = tailWriteFreely(ctx, cfgFilePath, debug, "-create-admin", fmt.Sprintf("%s:%s", adminUser, adminPass))
+
+			browser.Open(backend.baseURL())
+
+			sources := watchSources(opts, siteConfig, sites)
+			watcher, err := newWatcher(sites, sources, debug)
 			if err != nil {
 				return err
 			}
-
-			go func() {
-				err = tailWriteFreely(ctx, cfgFilePath, debug)
+			defer func() {
+				err := watcher.Close()
 				if err != nil {
-					debug.Printf("error while executing writefreely: %v", err)
+					debug.Printf("error closing watcher: %v", err)
 				}
-				cancel()
 			}()
 
-			addr := net.JoinHostPort(bind, strconv.Itoa(port))
+			// reloadSite reloads the config and template and publishes every page
+			// again.
+			reloadSite := func() {
+				newConfig, err := loadSiteConfig()
+				if err != nil {
+					logger.Printf("error reloading config, keeping the previous preview: %v", err)
+					return
+				}
+				newOpts := newPublishOpts(newConfig)
+				newOpts.createCollections = true
+				newOpts.drafts, newOpts.future, newOpts.expired = opts.drafts, opts.future, opts.expired
+				newOpts.banners = true
+				if contentFlag {
+					newOpts.content = opts.content
+				}
+				newSites, err := languageSites(newOpts, newConfig)
+				if err != nil {
+					logger.Printf("error reloading config, keeping the previous preview: %v", err)
+					return
+				}
+				err = backend.republish(newOpts, newConfig)
+				if err != nil {
+					logger.Printf("error publishing pages: %v", err)
+					return
+				}
+				opts, siteConfig, sites = newOpts, newConfig, newSites
+				reload.notify(reloadCurrent)
 
-			// Wait until writefreely becomes available.
-			var connected bool
-			for i := 0; i < 5; i++ {
-				const timeout = 1 * time.Second
-				logger.Printf("waiting %s for writefreely to accept connections…", timeout)
-				conn, err := net.Dial("tcp", addr)
-				if err == nil {
-					err = conn.Close()
-					if err != nil {
-						debug.Printf("error closing temporary TCP connection: %v", err)
-					}
-					logger.Println("connected to writefreely!")
-					connected = true
-					break
+				// The content directories or the template file may have changed.
+				sources = watchSources(opts, siteConfig, sites)
+				newW, err := newWatcher(sites, sources, debug)
+				if err != nil {
+					logger.Printf("error watching for changes, keeping the previous watcher: %v", err)
+					return
 				}
-				time.Sleep(timeout)
-			}
-			if !connected {
-				return fmt.Errorf("failed to connect to writefreely, did it start?")
+				err = watcher.Close()
+				if err != nil {
+					debug.Printf("error closing watcher: %v", err)
+				}
+				watcher = newW
 			}
 
-			baseAddr := "http://" + addr
-			client := writeas.NewClientWith(writeas.Config{
-				URL: baseAddr + "/api",
-			})
-			authUser, err := client.LogIn(adminUser, adminPass)
-			if err != nil {
-				return err
-			}
-			debug.Printf("logged in as: %+v", authUser)
+			// updatePage brings the post for pagePath in line with the current
+			// state of the file, whatever sequence of events led to it.
+			updatePage := func(pagePath string) {
+				site, ok := siteFor(sites, pagePath)
+				if !ok || site.opts.ignore.Match(pagePath, false) {
+					debug.Printf("skipping event on ignored file %s…", pagePath)
+					return
+				}
+				removeOld := func() {
+					err := backend.removePage(pagePath)
+					if err != nil {
+						logger.Printf("error removing old post %s: %v", pagePath, err)
+					}
+				}
+				info, err := os.Stat(pagePath)
+				if err != nil || info.IsDir() {
+					// The file was removed or renamed, removing the post is all we need
+					// to do.
+					removeOld()
+					return
+				}
+				if !blog.IsPage(site.opts.content, pagePath, site.opts.ignore) {
+					debug.Printf("skipping event on non-page file %s…", pagePath)
+					removeOld()
+					return
+				}
 
-			compiledTmpl, posted, collections, err := publish(opts, siteConfig, client, logger, debug)
-			if err != nil {
-				return err
+				// The change may have introduced or fixed a slug collision or
+				// changed a translation, so rebuild the index before publishing.
+				site.opts.pages, err = buildPageIndex(sites, debug)
+				if err != nil {
+					logger.Printf("not publishing %s: %v", pagePath, err)
+					removeOld()
+					return
+				}
+				postURL, err := backend.publishPage(pagePath, site)
+				if err != nil {
+					logger.Printf("error publishing file %s: %v", pagePath, err)
+					return
+				}
+				if postURL != "" {
+					reload.notify(postURL)
+				}
 			}
 
-			browser.Open(baseAddr)
+			// Editors often save files by writing a temporary file and renaming it,
+			// or by truncating and then writing the file, so wait for the events
+			// for each file to settle before looking at it.
+			pending := newDebouncer(debounceWindow)
+			defer pending.stop()
 
-			watcher, err := newWatcher(opts.content, debug)
-			if err != nil {
-				return fmt.Errorf("error watching %s for changes: %w", opts.content, err)
+			// Pages that are held back until their publish date are published when
+			// it arrives.
+			var (
+				scheduled     []scheduledPage
+				scheduleTimer *time.Timer
+				scheduleC     <-chan time.Time
+			)
+			reschedule := func() {
+				if scheduleTimer != nil {
+					scheduleTimer.Stop()
+				}
+				scheduleC = nil
+				if opts.future {
+					return
+				}
+				var err error
+				scheduled, err = scheduledPages(opts, siteConfig, time.Now(), debug)
+				if err != nil {
+					logger.Printf("error finding scheduled pages: %v", err)
+					return
+				}
+				if len(scheduled) == 0 {
+					return
+				}
+				debug.Printf("next page to publish is %s at %s", scheduled[0].Path, scheduled[0].Date)
+				scheduleTimer = time.NewTimer(time.Until(scheduled[0].Date))
+				scheduleC = scheduleTimer.C
 			}
+			reschedule()
 			defer func() {
-				err := watcher.Close()
-				if err != nil {
-					debug.Printf("error closing %s watcher: %v", opts.content, err)
+				if scheduleTimer != nil {
+					scheduleTimer.Stop()
 				}
 			}()
+
 			for {
 				select {
 				case <-sigs:
@@ -234,40 +400,82 @@ https://writefreely.org/
 					if !ok {
 						return nil
 					}
-					if ext := filepath.Ext(event.Name); ext != ".md" && ext != ".markdown" {
-						debug.Printf("skipping event on non-markdown file %s…", event.Name)
+					debug.Printf("event on file watcher: %v", event)
+					if event.Op == fsnotify.Chmod {
 						continue
 					}
-					debug.Printf("event on file watcher: %v", event)
-					switch event.Op {
-					case fsnotify.Chmod:
-						// Nothing to do here, skip this event.
+					if isSource(event.Name, sources) {
+						pending.add(event.Name)
 						continue
-					case fsnotify.Remove, fsnotify.Rename:
-						posted, err = removePost(event.Name, posted, client)
+					}
+					switch {
+					case event.Op&fsnotify.Create == fsnotify.Create:
+						info, err := os.Stat(event.Name)
+						if err != nil || !info.IsDir() {
+							break
+						}
+						// New directories (eg. page bundles) need to be watched, and any
+						// pages that were created in them before the watch was added need
+						// to be published.
+						site, ok := siteFor(sites, event.Name)
+						if !ok {
+							continue
+						}
+						files, err := watcher.addTree(event.Name, site.opts.ignore)
 						if err != nil {
-							logger.Printf("error removing post %s: %v", event.Name, err)
+							logger.Printf("error watching %s for changes: %v", event.Name, err)
+						}
+						for _, f := range files {
+							if isMarkdown(f) {
+								pending.add(f)
+							}
 						}
 						continue
-					case fsnotify.Write:
-						// Remove and then don't continue, we'll publish it again in just a
-						// moment.
-						posted, err = removePost(event.Name, posted, client)
-						if err != nil {
-							logger.Printf("error removing old post %s before update: %v", event.Name, err)
+					case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && watcher.watching(event.Name):
+						// No events are sent for the files in a directory that is moved, so
+						// check every page that was published from it.
+						watcher.removeTree(event.Name)
+						prefix := filepath.Clean(event.Name) + string(filepath.Separator)
+						for _, p := range backend.pagePaths() {
+							if strings.HasPrefix(filepath.Clean(p), prefix) {
+								pending.add(p)
+							}
 						}
-						// case fsnotify.Create:
-						// Nothing to do here, just continue to publishing.
+						continue
 					}
-
-					newPost, err := publishPost(event.Name, opts, siteConfig, nil, collections, compiledTmpl, client, logger, debug)
-					if err != nil {
-						logger.Printf("error publishing new file %s: %v", event.Name, err)
+					if !isMarkdown(event.Name) {
+						debug.Printf("skipping event on non-markdown file %s…", event.Name)
 						continue
 					}
-					if newPost != nil {
-						posted = append(posted, *newPost)
+					pending.add(event.Name)
+				case <-pending.C():
+					paths := pending.ready()
+					var changedSource bool
+					for _, p := range paths {
+						changedSource = changedSource || isSource(p, sources)
 					}
+					if changedSource {
+						// Every page is published again, so there is no need to handle
+						// the individual pages.
+						logger.Printf("%s changed, publishing all pages…", strings.Join(paths, ", "))
+						reloadSite()
+						reschedule()
+						continue
+					}
+					for _, p := range paths {
+						updatePage(p)
+					}
+					reschedule()
+				case <-scheduleC:
+					now := time.Now()
+					for _, p := range scheduled {
+						if p.Date.After(now) {
+							break
+						}
+						logger.Printf("publish date of %s has arrived, publishing…", p.Path)
+						updatePage(p.Path)
+					}
+					reschedule()
 				case err, ok := <-watcher.Errors:
 					if !ok {
 						return nil
@@ -279,11 +487,425 @@ https://writefreely.org/
 	}
 }
 
-func tailWriteFreely(ctx context.Context, cfgFile string, debug *log.Logger, args ...string) error {
+// startPreviewServer renders every page and starts the built in preview server
+// listening on addr.
+// The server is shut down when ctx is canceled.
+func startPreviewServer(ctx context.Context, addr string, reload *liveReload, opts publishOptions, siteConfig Config, logger, debug *log.Logger) (*previewServer, error) {
+	ln, err := listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := newPreviewServer("http://"+ln.Addr().String(), logger, debug)
+	err = srv.republish(opts, siteConfig)
+	if err != nil {
+		/* #nosec */
+		ln.Close()
+		return nil, err
+	}
+
+	serve(ctx, ln, reload.handler(srv), logger, debug)
+	logger.Printf("serving preview at %s", srv.baseURL())
+	return srv, nil
+}
+
+// serve serves handler on ln until ctx is canceled.
+func serve(ctx context.Context, ln net.Listener, handler http.Handler, logger, debug *log.Logger) {
+	httpSrv := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		err := httpSrv.Close()
+		if err != nil {
+			debug.Printf("error closing preview server: %v", err)
+		}
+	}()
+	go func() {
+		err := httpSrv.Serve(ln)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Printf("error serving preview: %v", err)
+		}
+	}()
+}
+
+// writeFreelyOptions configures how writefreely is run during preview.
+type writeFreelyOptions struct {
+	// addr is the address that the preview is served on.
+	addr string
+	// resources is the directory containing writefreelys templates and static
+	// files.
+	resources string
+	// dataDir is the directory that the database, keys, and config are kept in.
+	// If it is empty a temporary directory is used and removed on exit.
+	dataDir string
+	// timeout is how long to wait for writefreely to start serving requests.
+	timeout time.Duration
+}
+
+// writeFreelyBackend publishes pages to a local writefreely instance.
+type writeFreelyBackend struct {
+	// baseAddr is the address of writefreely, and publicAddr is the address of
+	// the proxy in front of it that adds live reloading.
+	baseAddr     string
+	publicAddr   string
+	dataDir      string
+	removeData   bool
+	client       *writeas.Client
+	api          *apiClient
+	compiledTmpl *template.Template
+	posted       []minimalPost
+	collections  []writeas.Collection
+	logger       *log.Logger
+	debug        *log.Logger
+}
+
+// startWriteFreely configures and launches writefreely behind a proxy listening
+// on addr, then publishes every page to it.
+// When writefreely exits, cancel is called.
+func startWriteFreely(ctx context.Context, cancel context.CancelFunc, wfOpts writeFreelyOptions, reload *liveReload, opts publishOptions, siteConfig Config, logger, debug *log.Logger) (wf *writeFreelyBackend, e error) {
+	_, err := exec.LookPath(binName)
+	if err != nil {
+		return nil, fmt.Errorf(`
+The 'writefreely' command could not be found.
+To use the writefreely preview mode, please install writefreely or run preview
+without the --writefreely option:
+
+https://writefreely.org/
+
+(original error: %w)`, err)
+	}
+
+	// Writefreely listens on a random port and the proxy that injects the live
+	// reload script listens on the address the user asked for.
+	ln, err := listen(wfOpts.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e != nil {
+			/* #nosec */
+			ln.Close()
+		}
+	}()
+	bind, _, err := net.SplitHostPort(wfOpts.addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := freePort(bind)
+	if err != nil {
+		return nil, fmt.Errorf("error finding a port for writefreely: %w", err)
+	}
+	wfAddr := net.JoinHostPort(bind, strconv.Itoa(port))
+
+	wf = &writeFreelyBackend{
+		baseAddr:   "http://" + wfAddr,
+		publicAddr: "http://" + ln.Addr().String(),
+		dataDir:    wfOpts.dataDir,
+		logger:     logger,
+		debug:      debug,
+	}
+	if wf.dataDir == "" {
+		wf.dataDir, err = ioutil.TempDir("", "blogsync")
+		if err != nil {
+			return nil, fmt.Errorf("can't create temporary directory: %v", err)
+		}
+		wf.removeData = true
+	}
+	defer func() {
+		if e != nil {
+			wf.close()
+		}
+	}()
+	wf.dataDir, err = filepath.Abs(wf.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	wfConfig := writeFreelyConfig{
+		Bind:            bind,
+		Host:            "http://" + ln.Addr().String(),
+		Collection:      siteConfig.Collection,
+		DBFile:          dbFileName,
+		Port:            port,
+		Resources:       wfOpts.resources,
+		SiteDescription: siteConfig.Description,
+		SiteName:        siteConfig.Title,
+	}
+	newKeys, newDB, err := mkDataDir(wf.dataDir, wfConfig, siteConfig.Preview, logger, debug)
+	if err != nil {
+		return nil, fmt.Errorf("can't create data directory: %v", err)
+	}
+
+	var cfgFilePath = filepath.Join(wf.dataDir, cfgFileName)
+
+	if newKeys {
+		err = runWriteFreely(ctx, cfgFilePath, debug, "-gen-keys")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if newDB {
+		err = runWriteFreely(ctx, cfgFilePath, debug, "-init-db")
+		if err != nil {
+			return nil, err
+		}
+		err = runWriteFreely(ctx, cfgFilePath, debug, "-create-admin", fmt.Sprintf("%s:%s", adminUser, adminPass))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Printf("using existing writefreely data in %s", wf.dataDir)
+	}
+
+	// Another process may start listening on the port between freePort finding
+	// it and writefreely listening on it, so if writefreely can't use the port
+	// pick another one and try again.
+	var exited chan error
+	for attempt := 1; ; attempt++ {
+		// Keep the output from startup so that it can be shown if writefreely
+		// exits before it is ready.
+		startupOutput := &captureWriter{}
+		exited = make(chan error, 1)
+		go func(exited chan<- error) {
+			err := tailWriteFreely(ctx, cfgFilePath, startupOutput, debug)
+			if err != nil {
+				debug.Printf("error while executing writefreely: %v", err)
+			}
+			exited <- err
+		}(exited)
+
+		err = waitReady(wf.baseAddr+"/api/me", wfOpts.timeout, exited, logger, debug)
+		startupOutput.stop()
+		if err == nil {
+			break
+		}
+		out := startupOutput.String()
+		if attempt < maxStartAttempts && errors.Is(err, errExited) && addrInUse(out) {
+			oldPort := wfConfig.Port
+			wfConfig.Port, err = freePort(bind)
+			if err != nil {
+				return nil, fmt.Errorf("error finding a port for writefreely: %w", err)
+			}
+			logger.Printf("port %d was taken before writefreely could listen on it, trying port %d…", oldPort, wfConfig.Port)
+			wf.baseAddr = "http://" + net.JoinHostPort(bind, strconv.Itoa(wfConfig.Port))
+			_, _, err = mkDataDir(wf.dataDir, wfConfig, siteConfig.Preview, logger, debug)
+			if err != nil {
+				return nil, fmt.Errorf("can't update data directory: %v", err)
+			}
+			continue
+		}
+		if out != "" {
+			return nil, fmt.Errorf("%w, writefreely output:\n\n%s", err, out)
+		}
+		return nil, err
+	}
+	go func() {
+		<-exited
+		cancel()
+	}()
+	logger.Println("connected to writefreely!")
+
+	wf.client = writeas.NewClientWith(writeas.Config{
+		URL: wf.baseAddr + "/api",
+	})
+	authUser, err := wf.client.LogIn(adminUser, adminPass)
+	if err != nil {
+		return nil, err
+	}
+	debug.Printf("logged in as: %+v", authUser)
+	wf.api = newAPIClient(writeas.Config{
+		URL:   wf.baseAddr + "/api",
+		Token: authUser.AccessToken,
+	}, debug)
+
+	err = wf.republish(opts, siteConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(wf.baseAddr)
+	if err != nil {
+		return nil, err
+	}
+	serve(ctx, ln, reload.handler(httputil.NewSingleHostReverseProxy(target)), logger, debug)
+	return wf, nil
+}
+
+// listen listens on addr, suggesting another port if addr is in use.
+func listen(addr string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE):
+		return nil, fmt.Errorf("error starting preview server: %w (use --port to pick another port or --port 0 to pick one automatically)", err)
+	case err != nil:
+		return nil, fmt.Errorf("error starting preview server: %w", err)
+	}
+	return ln, nil
+}
+
+// maxStartAttempts is the number of times to try starting writefreely when the
+// port picked for it is taken by another process.
+const maxStartAttempts = 3
+
+// errExited is returned by waitReady if the process exits before the server is
+// ready.
+var errExited = errors.New("writefreely exited during startup")
+
+// addrInUse reports whether the output of writefreely shows that it failed to
+// listen because the address was already in use.
+func addrInUse(out string) bool {
+	return strings.Contains(out, syscall.EADDRINUSE.Error())
+}
+
+// waitReady polls url until the server responds without an internal error,
+// the timeout passes, or the process serving it exits.
+func waitReady(url string, timeout time.Duration, exited <-chan error, logger, debug *log.Logger) error {
+	const interval = 250 * time.Millisecond
+
+	logger.Printf("waiting up to %s for writefreely to start…", timeout)
+	client := &http.Client{Timeout: interval}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// Any response from the API other than a server error, for example the
+		// 401 that is returned when we aren't logged in, means that it is ready.
+		resp, err := client.Get(url)
+		if err == nil {
+			/* #nosec */
+			resp.Body.Close()
+			if resp.StatusCode < 500 {
+				return nil
+			}
+			debug.Printf("writefreely is not ready yet: %s", resp.Status)
+		} else {
+			debug.Printf("writefreely is not ready yet: %v", err)
+		}
+
+		select {
+		case err := <-exited:
+			if err == nil {
+				return errExited
+			}
+			return fmt.Errorf("%w: %v", errExited, err)
+		case <-deadline.C:
+			return fmt.Errorf("writefreely was not ready after %s, try increasing --timeout", timeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// freePort returns a port on the host that nothing is listening on.
+// Since the port is released before it is returned, another process may start
+// listening on it before the caller does.
+func freePort(host string) (int, error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	return port, ln.Close()
+}
+
+func (wf *writeFreelyBackend) baseURL() string {
+	return wf.publicAddr
+}
+
+func (wf *writeFreelyBackend) publishPage(pagePath string, site langSite) (string, error) {
+	// Pass along the post that was previously published from this page so that
+	// publishPost updates it instead of creating a new post each time the page
+	// is saved.
+	var (
+		prev    minimalPost
+		hasPrev bool
+		old     []writeas.Post
+	)
+	for i, post := range wf.posted {
+		if post.filename == pagePath {
+			prev, hasPrev = post, true
+			old = append(old, writeas.Post{
+				ID:         post.id,
+				Token:      post.token,
+				Slug:       post.slug,
+				Collection: &writeas.Collection{Alias: post.collection},
+			})
+			wf.posted = append(wf.posted[:i], wf.posted[i+1:]...)
+			break
+		}
+	}
+
+	newPost, err := publishPost(pagePath, site.opts, site.config, &old, wf.collections, wf.compiledTmpl, wf.client, wf.logger, wf.debug)
+	if newPost == nil && hasPrev && len(old) == 0 {
+		// The old post was matched but updating it failed, so it still exists.
+		wf.posted = append(wf.posted, prev)
+		return "", err
+	}
+	// If the slug or collection changed, or the page is no longer published, the
+	// old post was not matched and is left over.
+	for _, post := range old {
+		delErr := wf.client.DeletePost(post.ID, post.Token)
+		if delErr != nil {
+			wf.logger.Printf("error removing old post %s: %v", pagePath, delErr)
+		}
+	}
+	if err != nil || newPost == nil {
+		return "", err
+	}
+	wf.posted = append(wf.posted, *newPost)
+	// Anonymous posts are not in a collection and are served by their ID.
+	if newPost.collection == "" {
+		return wf.publicAddr + "/" + newPost.id, nil
+	}
+	return wf.publicAddr + "/" + newPost.collection + "/" + newPost.slug, nil
+}
+
+func (wf *writeFreelyBackend) pagePaths() []string {
+	paths := make([]string, 0, len(wf.posted))
+	for _, post := range wf.posted {
+		paths = append(paths, post.filename)
+	}
+	return paths
+}
+
+func (wf *writeFreelyBackend) removePage(pagePath string) error {
+	var err error
+	wf.posted, err = removePost(pagePath, wf.posted, wf.client)
+	return err
+}
+
+// republish publishes all of the pages in opts, updating any posts that have
+// changed and deleting any posts that no longer have a matching page.
+func (wf *writeFreelyBackend) republish(opts publishOptions, siteConfig Config) error {
+	// Writefreely is only used for previewing, so any post that doesn't match a
+	// page is left over from a previous run or has been removed.
+	opts.del = true
+	opts.api = wf.api
+	opts.unlistedFile = filepath.Join(wf.dataDir, "unlisted.json")
+	compiledTmpl, posted, collections, err := publish(opts, siteConfig, wf.client, wf.logger, wf.debug)
+	if err != nil {
+		return err
+	}
+	wf.compiledTmpl, wf.posted, wf.collections = compiledTmpl, posted, collections
+	return nil
+}
+
+// close removes the data directory used by writefreely if it is temporary.
+func (wf *writeFreelyBackend) close() {
+	if !wf.removeData {
+		return
+	}
+	err := os.RemoveAll(wf.dataDir)
+	if err != nil {
+		wf.debug.Printf("error removing temporary dir %s: %v", wf.dataDir, err)
+	}
+}
+
+// tailWriteFreely runs writefreely with the config file and args, showing its
+// output and copying it to out.
+func tailWriteFreely(ctx context.Context, cfgFile string, out io.Writer, debug *log.Logger, args ...string) error {
 	args = append([]string{"-c", cfgFile}, args...)
 	cmd := exec.CommandContext(ctx, binName, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = io.MultiWriter(os.Stdout, out)
+	cmd.Stderr = io.MultiWriter(os.Stderr, out)
 	cmd.Stdin = os.Stdin
 	cmd.Dir = filepath.Dir(cfgFile)
 
@@ -292,7 +914,107 @@ func tailWriteFreely(ctx context.Context, cfgFile string, debug *log.Logger, arg
 	return cmd.Run()
 }
 
-func writeConfig(cfgFileName string, cfg writeFreelyConfig, debug *log.Logger) (err error) {
+// runWriteFreely runs a writefreely command that is expected to exit, including
+// its output in the error if it fails.
+func runWriteFreely(ctx context.Context, cfgFile string, debug *log.Logger, args ...string) error {
+	var out bytes.Buffer
+	err := tailWriteFreely(ctx, cfgFile, &out, debug, args...)
+	if err != nil {
+		return fmt.Errorf("error running writefreely %s: %w, output:\n\n%s", strings.Join(args, " "), err, out.String())
+	}
+	return nil
+}
+
+// captureWriter keeps everything written to it until stop is called.
+// It is safe for concurrent use.
+type captureWriter struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	stopped bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.stopped {
+		w.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// stop discards anything written after it is called.
+func (w *captureWriter) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+}
+
+func (w *captureWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// writeConfig writes the writefreely config to cfgFileName.
+// The base config file is merged on top of the default settings, followed by
+// any overrides from preview, and then the settings that blogsync controls are
+// set.
+func writeConfig(cfgFileName string, cfg writeFreelyConfig, preview PreviewConfig, logger, debug *log.Logger) (err error) {
+	render := func(tmpl string) (*iniFile, error) {
+		var buf bytes.Buffer
+		t := template.Must(template.New("cfg").Parse(tmpl))
+		err := t.Execute(&buf, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("error executing template: %w", err)
+		}
+		return parseINI(&buf)
+	}
+	ini, err := render(tmpFileTmpl)
+	if err != nil {
+		return err
+	}
+	managed, err := render(managedCfgTmpl)
+	if err != nil {
+		return err
+	}
+
+	if preview.BaseConfig != "" {
+		fd, err := os.Open(preview.BaseConfig)
+		if err != nil {
+			return fmt.Errorf("error opening base writefreely config: %w", err)
+		}
+		base, err := parseINI(fd)
+		if e := fd.Close(); e != nil {
+			debug.Printf("error closing %s: %v", preview.BaseConfig, e)
+		}
+		if err != nil {
+			return fmt.Errorf("error parsing base writefreely config %s: %w", preview.BaseConfig, err)
+		}
+		ini.merge(base)
+	}
+
+	for _, section := range []struct {
+		name      string
+		overrides map[string]interface{}
+	}{
+		{name: "app", overrides: preview.App},
+		{name: "server", overrides: preview.Server},
+	} {
+		keys := make([]string, 0, len(section.overrides))
+		for key := range section.overrides {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if _, ok := managed.get(section.name, key); ok {
+				logger.Printf("ignoring %s.%s in the Preview config, it is set by blogsync", section.name, key)
+				continue
+			}
+			ini.set(section.name, key, iniValue(section.overrides[key]))
+		}
+	}
+	ini.merge(managed)
+
 	cfgFile, err := os.Create(cfgFileName)
 	if err != nil {
 		return err
@@ -306,10 +1028,11 @@ func writeConfig(cfgFileName string, cfg writeFreelyConfig, debug *log.Logger) (
 		}
 	}()
 
-	t := template.Must(template.New("cfg").Parse(tmpFileTmpl))
-	err = t.Execute(cfgFile, cfg)
+	_, err = ini.WriteTo(cfgFile)
 	if err != nil {
-		return fmt.Errorf("error executing template: %w", err)
+		/* #nosec */
+		cfgFile.Close()
+		return fmt.Errorf("error writing config file %s: %w", cfgFile.Name(), err)
 	}
 	err = cfgFile.Close()
 	if err != nil {
@@ -319,47 +1042,65 @@ func writeConfig(cfgFileName string, cfg writeFreelyConfig, debug *log.Logger) (
 	return nil
 }
 
-func mkTmp(cfg writeFreelyConfig, debug *log.Logger) (tmpDir string, e error) {
+// iniValue formats a value from the site config for use in the writefreely
+// config.
+func iniValue(v interface{}) string {
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(vv, 'f', -1, 64)
+	default:
+		return fmt.Sprint(vv)
+	}
+}
+
+// mkDataDir creates the directories and files needed by writefreely in dir
+// if they do not already exist, and writes the config.
+// It reports whether the keys and database are new and need to be generated.
+func mkDataDir(dir string, cfg writeFreelyConfig, preview PreviewConfig, logger, debug *log.Logger) (newKeys, newDB bool, err error) {
 	const (
 		mode = os.ModeDir | 0755
 	)
 
-	tmpDir, err := ioutil.TempDir("", "blogsync")
-	if err != nil {
-		return tmpDir, err
-	}
-	defer func() {
-		if e != nil {
-			err := os.RemoveAll(tmpDir)
-			if err != nil {
-				debug.Printf("error during early removal of temporary dir %s: %v", tmpDir, err)
-			}
-		}
-	}()
-	cfg.Prefix = tmpDir
+	cfg.Prefix = dir
 
-	for _, dir := range []string{"keys", "pages", "static", "templates"} {
-		err := os.Mkdir(filepath.Join(tmpDir, dir), mode)
+	for _, sub := range []string{"keys", "pages", "static", "templates"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), mode)
 		if err != nil {
-			return tmpDir, err
+			return false, false, err
 		}
 	}
-
-	dbFile, err := os.Create(filepath.Join(tmpDir, cfg.DBFile))
+	keys, err := ioutil.ReadDir(filepath.Join(dir, "keys"))
 	if err != nil {
-		return tmpDir, err
+		return false, false, err
 	}
-	err = dbFile.Close()
-	if err != nil {
-		return tmpDir, err
+	newKeys = len(keys) == 0
+
+	dbPath := filepath.Join(dir, cfg.DBFile)
+	info, err := os.Stat(dbPath)
+	switch {
+	case os.IsNotExist(err) || (err == nil && info.Size() == 0):
+		newDB = true
+		dbFile, err := os.Create(dbPath)
+		if err != nil {
+			return false, false, err
+		}
+		err = dbFile.Close()
+		if err != nil {
+			return false, false, err
+		}
+	case err != nil:
+		return false, false, err
 	}
 
-	err = writeConfig(filepath.Join(tmpDir, cfgFileName), cfg, debug)
+	// The config is always written again since the ports may have changed.
+	err = writeConfig(filepath.Join(dir, cfgFileName), cfg, preview, logger, debug)
 	if err != nil {
-		return tmpDir, err
+		return false, false, err
 	}
 
-	return tmpDir, nil
+	return newKeys, newDB, nil
 }
 
 func decodeMeta(fname string, meta blog.Metadata, debug *log.Logger) error {
@@ -0,0 +1,160 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitReadyExited(t *testing.T) {
+	port, err := freePort("127.0.0.1")
+	if err != nil {
+		t.Fatalf("error finding free port: %v", err)
+	}
+	exited := make(chan error, 1)
+	exited <- errors.New("exit status 1")
+	discard := log.New(ioutil.Discard, "", 0)
+	err = waitReady("http://"+net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), time.Minute, exited, discard, discard)
+	if !errors.Is(err, errExited) {
+		t.Errorf("wrong error: want=%v, got=%v", errExited, err)
+	}
+}
+
+func TestAddrInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %v", err)
+	}
+	defer ln.Close()
+	_, err = net.Listen("tcp", ln.Addr().String())
+	if err == nil {
+		t.Fatalf("expected listening on a port in use to fail")
+	}
+	if out := "ERRO: " + err.Error() + "\n"; !addrInUse(out) {
+		t.Errorf("expected %q to be detected as the address being in use", out)
+	}
+	if addrInUse("ERRO: permission denied\n") {
+		t.Errorf("expected other errors not to be detected as the address being in use")
+	}
+}
+
+// Saving a page during a writefreely preview must update its post in place.
+func TestWriteFreelyPublishPage(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	discard := log.New(ioutil.Discard, "", 0)
+	opts := newPublishOpts(pt.config)
+	compiledTmpl, err := compileTmpl(opts.tmpl)
+	if err != nil {
+		t.Fatalf("error compiling template: %v", err)
+	}
+	wf := &writeFreelyBackend{
+		publicAddr:   "http://preview",
+		client:       pt.client,
+		compiledTmpl: compiledTmpl,
+		logger:       discard,
+		debug:        discard,
+	}
+	pagePath := filepath.Join(pt.dir, "first.md")
+	publishPage := func() string {
+		t.Helper()
+		sites, err := languageSites(opts, pt.config)
+		if err != nil {
+			t.Fatalf("error loading sites: %v", err)
+		}
+		site := sites[0]
+		site.opts.pages, err = buildPageIndex(sites, discard)
+		if err != nil {
+			t.Fatalf("error building index: %v", err)
+		}
+		postURL, err := wf.publishPage(pagePath, site)
+		if err != nil {
+			t.Fatalf("error publishing page: %v", err)
+		}
+		return postURL
+	}
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	if postURL := publishPage(); postURL != "http://preview/blog/first" {
+		t.Errorf("wrong URL: want=%q, got=%q", "http://preview/blog/first", postURL)
+	}
+	before := pt.posts()
+
+	pt.write("first.md", `title = "First"`, "Hello again")
+	publishPage()
+	after := pt.posts()
+	if !reflect.DeepEqual(keys(after), []string{"blog/first"}) || after["blog/first"].ID != before["blog/first"].ID {
+		t.Fatalf("expected post to be updated in place, got %v", after)
+	}
+	if content := after["blog/first"].Content; !strings.Contains(content, "Hello again") {
+		t.Errorf("post was not updated, got content %q", content)
+	}
+
+	// Changing the slug replaces the post.
+	pt.write("first.md", `title = "Renamed"`, "Hello")
+	publishPage()
+	if want := []string{"blog/renamed"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts after renaming: want=%q, got=%q", want, keys(pt.posts()))
+	}
+
+	// Pages that are no longer published have their post removed.
+	pt.write("first.md", "title = \"Renamed\"\ndraft = true", "Hello")
+	if postURL := publishPage(); postURL != "" {
+		t.Errorf("expected draft not to be published, got URL %q", postURL)
+	}
+	if len(pt.posts()) != 0 || len(wf.pagePaths()) != 0 {
+		t.Errorf("expected draft post to be removed, got %v and pages %q", keys(pt.posts()), wf.pagePaths())
+	}
+}
+
+// Posts that are not in a collection are previewed at their ID.
+func TestWriteFreelyPublishAnonymous(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	pt.config.Collection = ""
+	discard := log.New(ioutil.Discard, "", 0)
+	opts := newPublishOpts(pt.config)
+	compiledTmpl, err := compileTmpl(opts.tmpl)
+	if err != nil {
+		t.Fatalf("error compiling template: %v", err)
+	}
+	wf := &writeFreelyBackend{
+		publicAddr:   "http://preview",
+		client:       pt.client,
+		compiledTmpl: compiledTmpl,
+		logger:       discard,
+		debug:        discard,
+	}
+	pt.write("anon.md", `title = "Anon"`, "Hello")
+	sites, err := languageSites(opts, pt.config)
+	if err != nil {
+		t.Fatalf("error loading sites: %v", err)
+	}
+	site := sites[0]
+	site.opts.pages, err = buildPageIndex(sites, discard)
+	if err != nil {
+		t.Fatalf("error building index: %v", err)
+	}
+	postURL, err := wf.publishPage(filepath.Join(pt.dir, "anon.md"), site)
+	if err != nil {
+		t.Fatalf("error publishing page: %v", err)
+	}
+	ids := pt.postIDs()
+	if len(ids) != 1 {
+		t.Fatalf("wrong number of posts: want=1, got=%d", len(ids))
+	}
+	if want := "http://preview/" + ids[0]; postURL != want {
+		t.Errorf("wrong URL: want=%q, got=%q", want, postURL)
+	}
+}
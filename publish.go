@@ -9,12 +9,15 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"html"
 	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/russross/blackfriday/v2"
 	"github.com/writeas/go-writeas/v2"
@@ -28,9 +31,11 @@ const (
 )
 
 type tmplData struct {
-	Body   string
-	Meta   blog.Metadata
-	Config Config
+	Body         string
+	Meta         blog.Metadata
+	Config       Config
+	Resources    []string
+	Translations []translation
 }
 
 type publishOptions struct {
@@ -41,13 +46,40 @@ type publishOptions struct {
 	collection        string
 	content           string
 	tmpl              string
+	ignore            *blog.Ignore
+	pages             pageIndex
+
+	// Include drafts, pages with a publish date in the future, and pages with
+	// an expiry date in the past.
+	drafts  bool
+	future  bool
+	expired bool
+	// banners adds a notice to the top of posts that are only being published
+	// because of one of the options above.
+	banners bool
+
+	// Set when publishing one language of a multilingual site.
+	lang         string
+	unmarkedLang string
+	languages    map[string]LanguageConfig
+
+	// Set when publishing to a target that renames collections.
+	collections map[string]string
+
+	// Used to upload collection style sheets, if set.
+	api *apiClient
+
+	// The file that records which posts were unlisted, defaults to unlistedFile
+	// in the users config directory.
+	unlistedFile string
 }
 
 type minimalPost struct {
-	filename string
-	id       string
-	slug     string
-	token    string
+	filename   string
+	collection string
+	id         string
+	slug       string
+	token      string
 }
 
 func newPublishOpts(siteConfig Config) publishOptions {
@@ -58,8 +90,9 @@ func newPublishOpts(siteConfig Config) publishOptions {
 	}
 }
 
-func publishCmd(siteConfig Config, client *writeas.Client, logger, debug *log.Logger) *cli.Command {
+func publishCmd(siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) *cli.Command {
 	opts := newPublishOpts(siteConfig)
+	target := ""
 
 	flags := flag.NewFlagSet("publish", flag.ContinueOnError)
 	flags.BoolVar(&opts.del, "delete", opts.del, "Delete pages for which matching files cannot be found")
@@ -68,57 +101,146 @@ func publishCmd(siteConfig Config, client *writeas.Client, logger, debug *log.Lo
 	flags.StringVar(&opts.collection, "collection", opts.collection, "The default collection for pages that don't include `collection' in their frontmatter")
 	flags.StringVar(&opts.content, "content", opts.content, "A directory containing pages")
 	flags.StringVar(&opts.tmpl, "tmpl", opts.tmpl, "A template using Go's html/template format, to load from a file use @filename")
+	flags.StringVar(&target, "target", target, "A comma separated list of targets from the config file to publish to (defaults to all targets)")
+	flags.BoolVar(&opts.future, "future", opts.future, "Publish pages with a publish date in the future now instead of holding them back")
 
 	return &cli.Command{
 		Usage: "publish [options]",
 		Description: fmt.Sprintf(`Publishes Markdown files to write.as.
 
-Expects an API token to be exported as $%s.`, envToken),
+Expects an API token to be exported as $%s.
+
+If the config file contains any Targets, the site is published to each target
+(or only those selected with --target) using the URL, token, and collection
+names configured for the target instead.
+
+Pages with a publishDate (or date) in the future are not published until that
+date has passed, unless --future is given.
+If they were already published, their posts are left in place even with
+--delete.
+To see which pages are waiting to be published, or to publish them as their
+dates arrive, use the schedule command.
+
+Once the expiryDate of a page has passed, its post is deleted, or unlisted if
+ExpiryPolicy is set to "unlist" in the config file.
+This happens even if --delete is not given.
+
+The style sheet of each collection that is published to is uploaded if it is
+configured in the Collections section of the config file or found in
+%s, when run with --dry-run out of date style sheets are reported.`, envToken, filepath.Join(assetsDir, "<alias>.css")),
 		Flags: flags,
 		Run: func(cmd *cli.Command, args ...string) error {
-			_, _, _, err := publish(opts, siteConfig, client, logger, debug)
+			_, err := publishTargets(opts, target, siteConfig, clientConfig, torPort, logger, debug)
 			return err
 		},
 	}
 }
 
+// publishTargets publishes the site to each target selected by the comma
+// separated list of names in target, or using clientConfig if the site has no
+// targets.
+// It returns the paths of the pages that were published to every target.
+func publishTargets(opts publishOptions, target string, siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) (map[string]bool, error) {
+	if len(siteConfig.Targets) == 0 {
+		if target != "" {
+			return nil, fmt.Errorf("--target was specified but no targets are configured")
+		}
+		opts.api = newAPIClient(clientConfig, debug)
+		_, posted, _, err := publish(opts, siteConfig, writeas.NewClientWith(clientConfig), logger, debug)
+		published := make(map[string]bool, len(posted))
+		for _, post := range posted {
+			published[post.filename] = true
+		}
+		return published, err
+	}
+
+	targets, err := selectTargets(siteConfig.Targets, target)
+	if err != nil {
+		return nil, err
+	}
+	var failed []string
+	counts := make(map[string]int)
+	for _, t := range targets {
+		logger.Printf("publishing to target %s (%s)…", t.Name, t.URL)
+		cfg, err := t.config(torPort, debug)
+		if err != nil {
+			logger.Printf("target %s: %v", t.Name, err)
+			failed = append(failed, t.Name)
+			continue
+		}
+		targetOpts := opts
+		targetOpts.collections = t.Collections
+		targetOpts.api = newAPIClient(cfg, debug)
+		_, posted, _, err := publish(targetOpts, siteConfig, writeas.NewClientWith(cfg), logger, debug)
+		if err != nil {
+			logger.Printf("target %s: %v", t.Name, err)
+			failed = append(failed, t.Name)
+			continue
+		}
+		logger.Printf("target %s: published %d pages", t.Name, len(posted))
+		for _, post := range posted {
+			counts[post.filename]++
+		}
+	}
+	published := make(map[string]bool, len(counts))
+	for pagePath, n := range counts {
+		if n == len(targets) {
+			published[pagePath] = true
+		}
+	}
+	if len(failed) > 0 {
+		return published, fmt.Errorf("publishing failed for targets: %s", strings.Join(failed, ", "))
+	}
+	return published, nil
+}
+
 func publish(opts publishOptions, siteConfig Config, client *writeas.Client, logger, debug *log.Logger) (*template.Template, []minimalPost, []writeas.Collection, error) {
 	var collections []writeas.Collection
 
+	sites, err := languageSites(opts, siteConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Make sure that no two pages will overwrite one another before we make any
+	// changes.
+	pages, err := buildPageIndex(sites, debug)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	policy, err := expiryPolicy(siteConfig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	if opts.createCollections {
 		colls, err := client.GetUserCollections()
 		if err != nil {
-			logger.Printf("error fetching existing collections: %v", err)
+			return nil, nil, nil, fmt.Errorf("error fetching existing collections: %v", err)
+		}
+		if colls != nil {
+			collections = *colls
 		}
-		collections = *colls
 
-		collections = createCollectionIfNotExist(collections, client, debug, &writeas.CollectionParams{
-			Alias:       siteConfig.Collection,
-			Title:       siteConfig.Title,
-			Description: siteConfig.Description,
-		})
+		for _, site := range sites {
+			collections, err = createCollectionIfNotExist(collections, client, debug, &writeas.CollectionParams{
+				Alias:       opts.remoteCollection(site.config.Collection),
+				Title:       orDef(siteConfig.Collections[site.config.Collection].Title, site.config.Title),
+				Description: orDef(siteConfig.Collections[site.config.Collection].Description, site.config.Description),
+			})
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
 	}
 
-	compiledTmpl := template.New(defTmplName).Funcs(map[string]interface{}{
-		"join": path.Join,
-	})
-	tmplFile := strings.TrimPrefix(opts.tmpl, "@")
-	var err error
-	if opts.tmpl != tmplFile {
-		// If the template argument starts with "@" it is a filename that we
-		// should load.
-		compiledTmpl, err = compiledTmpl.ParseFiles(tmplFile)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("error compiling template file %s: %v", tmplFile, err)
-		}
-		compiledTmpl = compiledTmpl.Lookup(tmplFile)
-	} else {
-		tmplFile = defTmplName
-		// Otherwise, it is a raw template and we should compile it.
-		compiledTmpl, err = compiledTmpl.Parse(opts.tmpl)
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("error compiling template: %v", err)
-		}
+	if opts.api != nil {
+		syncStyleSheets(opts.api, siteConfig, pages.collections(), opts.collections, opts.dryRun, logger, debug)
+	}
+
+	compiledTmpl, err := compileTmpl(opts.tmpl)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	var posts []writeas.Post
@@ -134,15 +256,52 @@ func publish(opts publishOptions, siteConfig Config, client *writeas.Client, log
 	posts = *p
 
 	posted := make([]minimalPost, 0, len(posts))
-	err = blog.WalkPages(opts.content, func(pagePath string, info os.FileInfo, err error) error {
-		newPost, err := publishPost(pagePath, opts, siteConfig, posts, collections, compiledTmpl, client, logger, debug)
-		if newPost != nil {
-			posted = append(posted, *newPost)
+	for _, site := range sites {
+		site.opts.pages = pages
+		err = site.opts.walkPages(func(pagePath string, info os.FileInfo, err error) error {
+			newPost, err := publishPost(pagePath, site.opts, site.config, &posts, collections, compiledTmpl, client, logger, debug)
+			if newPost != nil {
+				posted = append(posted, *newPost)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// Posts for pages that have expired are handled separately from posts that
+	// have no page at all so that they are removed even without --delete.
+	now := time.Now()
+	if !opts.expired {
+		unlistedPath := opts.unlistedFile
+		if unlistedPath == "" {
+			unlistedPath = userConfigPath(unlistedFile, debug)
+		}
+		unlisted, err := loadUnlisted(unlistedPath)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("error reading unlisted posts: %w", err)
+		}
+		serverUnlisted := unlisted.server(client.BaseURL())
+
+		var expired []expiredPost
+		posts, expired = splitExpired(posts, pages, serverUnlisted, opts, now)
+		removeExpired(expired, policy, opts.dryRun, serverUnlisted, client, opts.api, logger, debug)
+		if len(expired) > 0 && !opts.dryRun {
+			err = unlisted.save(unlistedPath)
+			if err != nil {
+				logger.Printf("error recording unlisted posts: %v", err)
+			}
+		}
+	}
+	// Posts for pages that are being held back until their publish date still
+	// have a page, so they are left alone even with --delete.
+	if !opts.future {
+		var held []heldPost
+		posts, held = splitHeldBack(posts, pages, opts, now)
+		for _, post := range held {
+			logger.Printf("%s is held back until %s, leaving post %q in place", post.path, post.date.Format(noticeTimeFormat), post.Slug)
 		}
-		return err
-	})
-	if err != nil {
-		return nil, nil, nil, err
 	}
 
 	// Delete remaining posts for which we couldn't find a matching file.
@@ -163,12 +322,105 @@ func publish(opts publishOptions, siteConfig Config, client *writeas.Client, log
 	return compiledTmpl, posted, collections, nil
 }
 
-func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts []writeas.Post, collections []writeas.Collection, compiledTmpl *template.Template, client *writeas.Client, logger, debug *log.Logger) (post *minimalPost, err error) {
+// compileTmpl compiles the template used to render pages.
+// If tmpl starts with "@" it is the name of a file to load the template from.
+func compileTmpl(tmpl string) (*template.Template, error) {
+	compiledTmpl := template.New(defTmplName).Funcs(map[string]interface{}{
+		"join": path.Join,
+	})
+	var err error
+	tmplFile := strings.TrimPrefix(tmpl, "@")
+	if tmpl != tmplFile {
+		// If the template argument starts with "@" it is a filename that we
+		// should load.
+		compiledTmpl, err = compiledTmpl.ParseFiles(tmplFile)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling template file %s: %v", tmplFile, err)
+		}
+		compiledTmpl = compiledTmpl.Lookup(tmplFile)
+	} else {
+		// Otherwise, it is a raw template and we should compile it.
+		compiledTmpl, err = compiledTmpl.Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("error compiling template: %v", err)
+		}
+	}
+	return compiledTmpl, nil
+}
+
+// renderedPost is a page that has been rendered and is ready to publish.
+type renderedPost struct {
+	meta       blog.Metadata
+	collConfig CollectionConfig
+	params     *writeas.PostParams
+
+	draft   bool
+	future  bool
+	expired bool
+	expiry  time.Time
+}
+
+// notices returns a message for each reason that the post would not normally
+// be published.
+func (r *renderedPost) notices() []string {
+	var notices []string
+	if r.draft {
+		notices = append(notices, "This post is a draft.")
+	}
+	if r.future {
+		notices = append(notices, fmt.Sprintf("This post is scheduled to be published on %s.", r.params.Created.Format(noticeTimeFormat)))
+	}
+	if r.expired {
+		notices = append(notices, fmt.Sprintf("This post expired on %s.", r.expiry.Format(noticeTimeFormat)))
+	}
+	return notices
+}
+
+const noticeTimeFormat = "January 2, 2006 at 15:04 MST"
+
+// noticeBanner formats notices so that they can be added to the top of a post.
+func noticeBanner(notices []string) string {
+	var b strings.Builder
+	b.WriteString(`<div class="blogsync-notice" style="border: 2px solid #c60; background: #fff3e0; color: #333; padding: 0.5em 1em; margin-bottom: 1em;">`)
+	for _, notice := range notices {
+		fmt.Fprintf(&b, "<p><strong>Preview:</strong> %s</p>", html.EscapeString(notice))
+	}
+	b.WriteString("</div>\n\n")
+	return b.String()
+}
+
+// pageDates returns the publish date of a page, which is its publishDate or
+// date, and its expiry date.
+// Either may be the zero time if the page does not set them.
+func pageDates(meta blog.Metadata, loc *time.Location) (date, expiry time.Time) {
+	date = timeOrDef(meta.GetTimeIn("publishDate", loc), meta.GetTimeIn("date", loc))
+	return date, meta.GetTimeIn("expiryDate", loc)
+}
+
+// publishes reports whether a page that may be a draft and has the given
+// publish and expiry dates is published at now with opts.
+// It must match the checks made by renderPost.
+func (opts publishOptions) publishes(draft bool, date, expiry, now time.Time) bool {
+	switch {
+	case draft && !opts.drafts:
+		return false
+	case date.After(now) && !opts.future:
+		return false
+	case !expiry.IsZero() && !expiry.After(now) && !opts.expired:
+		return false
+	}
+	return true
+}
+
+// renderPost reads the page at pagePath and renders it using the template.
+// If the page should not be published the reason is logged and nil is
+// returned.
+func renderPost(pagePath string, opts publishOptions, siteConfig Config, compiledTmpl *template.Template, logger, debug *log.Logger) *renderedPost {
 	debug.Printf("opening %s", pagePath)
 	fd, err := os.Open(pagePath)
 	if err != nil {
 		logger.Printf("error opening %s, skipping: %v", pagePath, err)
-		return nil, nil
+		return nil
 	}
 	defer func() {
 		if err := fd.Close(); err != nil {
@@ -181,26 +433,43 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 	header, err := meta.Decode(f)
 	if err != nil {
 		logger.Printf("error decoding metadata for %s, skipping: %v", pagePath, err)
-		return nil, nil
+		return nil
 	}
 	// This may seem unnecessary, but I don't plan on supporting YAML
 	// headers forever to keep things simple, so go ahead and forbid
 	// publishing with them to encourage people to convert their blogs over.
 	if header == blog.HeaderYAML {
 		logger.Printf(`file %s has a YAML header, try converting it by running "%s convert", skipping`, pagePath, os.Args[0])
-		return nil, nil
+		return nil
 	}
 
 	draft := meta.GetBool("draft")
-	if draft {
+	if draft && !opts.drafts {
 		debug.Printf("skipping draft %s", pagePath)
-		return nil, nil
+		return nil
+	}
+
+	loc, err := meta.Location(siteConfig.location)
+	if err != nil {
+		logger.Printf("invalid time zone in %s, using %s instead: %v", pagePath, loc, err)
+	}
+	now := time.Now()
+	created, expiry := pageDates(meta, loc)
+	future := created.After(now)
+	if future && !opts.future {
+		logger.Printf("holding back %s until its publish date, %s", pagePath, created.Format(noticeTimeFormat))
+		return nil
+	}
+	expired := !expiry.IsZero() && !expiry.After(now)
+	if expired && !opts.expired {
+		debug.Printf("skipping %s which expired on %s", pagePath, expiry)
+		return nil
 	}
 
 	title := meta.GetString("title")
 	if title == "" {
 		logger.Printf("invalid or empty title in %s, skipping", pagePath)
-		return nil, nil
+		return nil
 	}
 
 	// Deliberately shadow collection so that we don't end up mutating the
@@ -209,11 +478,13 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 	if col := meta.GetString("collection"); col != "" {
 		collection = col
 	}
+	collConfig := siteConfig.Collections[collection]
+	collection = opts.remoteCollection(collection)
 
 	body, err := ioutil.ReadAll(f)
 	if err != nil {
 		logger.Printf("error reading body from %s, skipping: %v", pagePath, err)
-		return nil, nil
+		return nil
 	}
 	body = bytes.TrimSpace(body)
 	body = blackfriday.Run(body,
@@ -228,25 +499,88 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 			}),
 		}))
 
+	page, err := blog.ReadPage(pagePath, opts.ignore)
+	if err != nil {
+		logger.Printf("error reading page bundle for %s, skipping: %v", pagePath, err)
+		return nil
+	}
+
 	var bodyBuf strings.Builder
 	err = compiledTmpl.Execute(&bodyBuf, tmplData{
-		Body:   string(body),
-		Meta:   meta,
-		Config: siteConfig,
+		Body:         string(body),
+		Meta:         meta,
+		Config:       siteConfig,
+		Resources:    page.Resources,
+		Translations: opts.pages.translations(pagePath, opts),
 	})
 	if err != nil {
 		logger.Printf("error executing template for file %s: %v", pagePath, err)
-		return nil, nil
+		return nil
 	}
 	if bodyBuf.Len() == 0 {
 		// Apparently write.as doesn't like posts that don't have a body.
 		logger.Printf("post %s has no body, skipping", pagePath)
+		return nil
+	}
+
+	slug := opts.pages[pagePath].slug
+	if slug == "" {
+		slug = siteConfig.Slugs.Slug(opts.content, pagePath, meta, siteConfig.Permalinks, loc)
+	}
+	createdPtr := &created
+	if created.IsZero() {
+		createdPtr = nil
+	}
+	rtl := meta.GetBool("rtl")
+	lang := meta.GetString("lang")
+	if lang == "" {
+		lang = siteConfig.Language
+	}
+	updated := timeOrDef(meta.GetTimeIn("lastmod", loc), created)
+
+	return &renderedPost{
+		meta:       meta,
+		collConfig: collConfig,
+		params: &writeas.PostParams{
+			Content:  bodyBuf.String(),
+			Created:  createdPtr,
+			Font:     orDef(meta.GetString("font"), "norm"),
+			IsRTL:    &rtl,
+			Language: &lang,
+			Slug:     slug,
+			Title:    title,
+			Updated:  &updated,
+
+			Collection: collection,
+		},
+		draft:   draft,
+		future:  future,
+		expired: expired,
+		expiry:  expiry,
+	}
+}
+
+// publishPost renders the page at pagePath and creates or updates the matching
+// post in posts.
+// The matched post is removed from posts so that once every page has been
+// published only the posts without a matching page remain.
+func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts *[]writeas.Post, collections []writeas.Collection, compiledTmpl *template.Template, client *writeas.Client, logger, debug *log.Logger) (post *minimalPost, err error) {
+	rendered := renderPost(pagePath, opts, siteConfig, compiledTmpl, logger, debug)
+	if rendered == nil {
 		return nil, nil
 	}
+	meta, collConfig, params := rendered.meta, rendered.collConfig, rendered.params
+	slug, collection := params.Slug, params.Collection
+	if notices := rendered.notices(); opts.banners && len(notices) > 0 {
+		params.Content = noticeBanner(notices) + params.Content
+	}
 
-	slug := blog.Slug(pagePath, meta)
 	var existingPost *writeas.Post
-	for i, post := range posts {
+	var remaining []writeas.Post
+	if posts != nil {
+		remaining = *posts
+	}
+	for i, post := range remaining {
 		var postCollection string
 		if post.Collection != nil {
 			postCollection = post.Collection.Alias
@@ -254,43 +588,18 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 
 		if slug == post.Slug && collection == postCollection {
 			existingPost = &post
-			posts = append(posts[:i], posts[i+1:]...)
+			*posts = append((*posts)[:i], (*posts)[i+1:]...)
 			break
 		}
 	}
 
-	created := timeOrDef(meta.GetTime("publishDate"), meta.GetTime("date"))
-	createdPtr := &created
-	if created.IsZero() {
-		createdPtr = nil
-	}
-	rtl := meta.GetBool("rtl")
-	lang := meta.GetString("lang")
-	if lang == "" {
-		lang = siteConfig.Language
-	}
-	updated := timeOrDef(meta.GetTime("lastmod"), created)
-
 	var postID, postTok string
 	if existingPost != nil {
 		postID = existingPost.ID
 		postTok = existingPost.Token
 	}
-	params := &writeas.PostParams{
-		ID:    postID,
-		Token: postTok,
-
-		Content:  bodyBuf.String(),
-		Created:  createdPtr,
-		Font:     orDef(meta.GetString("font"), "norm"),
-		IsRTL:    &rtl,
-		Language: &lang,
-		Slug:     slug,
-		Title:    title,
-		Updated:  &updated,
-
-		Collection: collection,
-	}
+	params.ID = postID
+	params.Token = postTok
 
 	var skipUpdate bool
 	if existingPost == nil {
@@ -306,10 +615,14 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 
 	if !opts.dryRun && !skipUpdate {
 		if opts.createCollections {
-			collections = createCollectionIfNotExist(collections, client, debug, &writeas.CollectionParams{
-				Alias: params.Collection,
-				Title: params.Collection,
+			collections, err = createCollectionIfNotExist(collections, client, debug, &writeas.CollectionParams{
+				Alias:       params.Collection,
+				Title:       orDef(collConfig.Title, params.Collection),
+				Description: collConfig.Description,
 			})
+			if err != nil {
+				return nil, err
+			}
 		}
 		if postID == "" {
 			post, err := client.CreatePost(params)
@@ -363,26 +676,35 @@ func publishPost(pagePath string, opts publishOptions, siteConfig Config, posts
 	}
 
 	return &minimalPost{
-		filename: pagePath,
-		slug:     slug,
-		id:       postID,
-		token:    postTok,
+		filename:   pagePath,
+		collection: collection,
+		slug:       slug,
+		id:         postID,
+		token:      postTok,
 	}, nil
 }
 
-func createCollectionIfNotExist(colls []writeas.Collection, client *writeas.Client, debug *log.Logger, coll *writeas.CollectionParams) []writeas.Collection {
+// createCollectionIfNotExist creates the collection described by coll unless
+// one with the same alias is already in colls, and returns colls with the new
+// collection added.
+// Posts without a collection need nothing created, so an empty alias is
+// ignored.
+func createCollectionIfNotExist(colls []writeas.Collection, client *writeas.Client, debug *log.Logger, coll *writeas.CollectionParams) ([]writeas.Collection, error) {
+	if coll.Alias == "" {
+		return colls, nil
+	}
 	for _, c := range colls {
 		if c.Alias == coll.Alias {
-			return colls
+			return colls, nil
 		}
 	}
 	debug.Printf("creating collection %s…", coll.Alias)
 	newColl, err := client.CreateCollection(coll)
 	if err != nil {
-		debug.Printf("error creating collection %s: %v", coll.Alias, err)
+		return colls, fmt.Errorf("error creating collection %s: %v", coll.Alias, err)
 	}
-	if coll != nil {
+	if newColl != nil {
 		colls = append(colls, *newColl)
 	}
-	return colls
+	return colls, nil
 }
@@ -0,0 +1,381 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/writeas/go-writeas/v2"
+
+	"mellium.im/blogsync/internal/writeastest"
+)
+
+// publishTest is a site in a temporary directory that is published to a fake
+// write.as server.
+type publishTest struct {
+	t      *testing.T
+	srv    *writeastest.Server
+	client *writeas.Client
+	api    *apiClient
+	dir    string
+	config Config
+	logs   bytes.Buffer
+}
+
+// newPublishTest creates an empty site that publishes to the collection "blog"
+// on a new fake server.
+// The caller should call close when finished.
+func newPublishTest(t *testing.T) *publishTest {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "blogsync")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	pt := &publishTest{
+		t:   t,
+		srv: writeastest.NewServer(),
+		dir: dir,
+		config: Config{
+			Collection: "blog",
+			Content:    dir,
+			location:   time.UTC,
+		},
+	}
+	cfg := writeas.Config{URL: pt.srv.APIURL(), Token: pt.srv.AddUser("me", "pass")}
+	pt.client = writeas.NewClientWith(cfg)
+	pt.api = newAPIClient(cfg, log.New(ioutil.Discard, "", 0))
+	err = pt.api.do(http.MethodPost, "/collections", collectionUpdate{Alias: "blog"}, nil)
+	if err != nil {
+		t.Fatalf("error creating collection: %v", err)
+	}
+	return pt
+}
+
+func (pt *publishTest) close() {
+	pt.srv.Close()
+	os.RemoveAll(pt.dir)
+	os.Remove(pt.dir + ".unlisted.json")
+}
+
+// write creates or replaces the page name in the content directory with a TOML
+// header containing meta and the given body, or removes it if meta is empty.
+func (pt *publishTest) write(name, meta, body string) {
+	pt.t.Helper()
+	fname := filepath.Join(pt.dir, filepath.FromSlash(name))
+	if meta == "" {
+		err := os.Remove(fname)
+		if err != nil {
+			pt.t.Fatalf("error removing %s: %v", name, err)
+		}
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(fname), 0755)
+	if err != nil {
+		pt.t.Fatalf("error creating dir for %s: %v", name, err)
+	}
+	err = ioutil.WriteFile(fname, []byte("+++\n"+meta+"\n+++\n"+body+"\n"), 0644)
+	if err != nil {
+		pt.t.Fatalf("error writing %s: %v", name, err)
+	}
+}
+
+// publish publishes the site using the default options modified by setOpts,
+// if it is not nil.
+func (pt *publishTest) publish(setOpts func(*publishOptions)) []minimalPost {
+	pt.t.Helper()
+	opts := newPublishOpts(pt.config)
+	opts.api = pt.api
+	opts.unlistedFile = pt.dir + ".unlisted.json"
+	if setOpts != nil {
+		setOpts(&opts)
+	}
+	_, posted, _, err := publish(opts, pt.config, pt.client, log.New(&pt.logs, "", 0), log.New(ioutil.Discard, "", 0))
+	if err != nil {
+		pt.t.Fatalf("error publishing: %v", err)
+	}
+	return posted
+}
+
+// posts returns the posts on the server keyed by "<collection>/<slug>", or by
+// "/<title>" for posts that are not in a collection.
+func (pt *publishTest) posts() map[string]writeastest.Post {
+	posts := make(map[string]writeastest.Post)
+	for _, post := range pt.srv.Posts() {
+		key := "/" + post.Title
+		if post.Collection != nil {
+			key = post.Collection.Alias + "/" + post.Slug
+		}
+		posts[key] = post
+	}
+	return posts
+}
+
+// keys returns the sorted keys of posts.
+func keys(posts map[string]writeastest.Post) []string {
+	k := make([]string, 0, len(posts))
+	for key := range posts {
+		k = append(k, key)
+	}
+	sort.Strings(k)
+	return k
+}
+
+func TestPublishCreateUpdateDelete(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	pt.write("second.md", `title = "Second"`, "World")
+	posted := pt.publish(nil)
+	if len(posted) != 2 {
+		t.Fatalf("wrong number of posts published: want=2, got=%d", len(posted))
+	}
+	before := pt.posts()
+	if want := []string{"blog/first", "blog/second"}; !reflect.DeepEqual(keys(before), want) {
+		t.Fatalf("wrong posts after first publish: want=%q, got=%q", want, keys(before))
+	}
+
+	pt.write("first.md", `title = "First"`, "Hello again")
+	pt.publish(nil)
+	after := pt.posts()
+	if after["blog/first"].ID != before["blog/first"].ID {
+		t.Errorf("expected post to be updated in place, got new ID %q", after["blog/first"].ID)
+	}
+	if content := after["blog/first"].Content; !strings.Contains(content, "Hello again") {
+		t.Errorf("post was not updated, got content %q", content)
+	}
+
+	// Without --delete posts without a page are only reported.
+	pt.write("second.md", "", "")
+	pt.publish(nil)
+	if want := []string{"blog/first", "blog/second"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts without --delete: want=%q, got=%q", want, keys(pt.posts()))
+	}
+	if !strings.Contains(pt.logs.String(), `no file found matching post "second", re-run with --delete`) {
+		t.Errorf("expected missing page to be reported, got %q", pt.logs.String())
+	}
+
+	// With --dry-run nothing is deleted.
+	pt.publish(func(opts *publishOptions) {
+		opts.del = true
+		opts.dryRun = true
+	})
+	if want := []string{"blog/first", "blog/second"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts after dry run: want=%q, got=%q", want, keys(pt.posts()))
+	}
+}
+
+// Posts that are matched to a page must be removed from the list of orphans,
+// otherwise --delete removes posts that were just published.
+func TestPublishDeleteOrphans(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	pt.write("second.md", `title = "Second"`, "World")
+	pt.write("third.md", `title = "Third"`, "!")
+	pt.publish(nil)
+	before := pt.posts()
+
+	pt.write("second.md", "", "")
+	pt.publish(func(opts *publishOptions) {
+		opts.del = true
+	})
+	after := pt.posts()
+	if want := []string{"blog/first", "blog/third"}; !reflect.DeepEqual(keys(after), want) {
+		t.Fatalf("wrong posts after --delete: want=%q, got=%q", want, keys(after))
+	}
+	for _, key := range keys(after) {
+		if after[key].ID != before[key].ID {
+			t.Errorf("post %s was recreated: want ID %q, got=%q", key, before[key].ID, after[key].ID)
+		}
+	}
+}
+
+func TestPublishHeldBack(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	pt.write("second.md", "title = \"Second\"\npublishDate = 2999-01-01T00:00:00Z", "World")
+	pt.publish(nil)
+	if want := []string{"blog/first"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Fatalf("wrong posts: want=%q, got=%q", want, keys(pt.posts()))
+	}
+
+	// A page that was already published and then had its date moved into the
+	// future still has a page, so its post must not be deleted.
+	pt.write("first.md", "title = \"First\"\npublishDate = 2999-01-01T00:00:00Z", "Hello")
+	pt.publish(func(opts *publishOptions) {
+		opts.del = true
+	})
+	if want := []string{"blog/first"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts after --delete: want=%q, got=%q", want, keys(pt.posts()))
+	}
+	if !strings.Contains(pt.logs.String(), `leaving post "first" in place`) {
+		t.Errorf("expected held back post to be reported, got %q", pt.logs.String())
+	}
+
+	pt.publish(func(opts *publishOptions) {
+		opts.future = true
+	})
+	if want := []string{"blog/first", "blog/second"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts with --future: want=%q, got=%q", want, keys(pt.posts()))
+	}
+}
+
+func TestPublishPin(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("first.md", "title = \"First\"\npin = 1", "Hello")
+	pt.write("second.md", `title = "Second"`, "World")
+	pt.publish(nil)
+	posts := pt.posts()
+	if pin := posts["blog/first"].PinnedPosition; pin == nil || *pin != 1 {
+		t.Errorf("expected first post to be pinned at position 1, got %v", pin)
+	}
+	if pin := posts["blog/second"].PinnedPosition; pin != nil {
+		t.Errorf("expected second post not to be pinned, got %d", *pin)
+	}
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	pt.publish(nil)
+	if pin := pt.posts()["blog/first"].PinnedPosition; pin != nil {
+		t.Errorf("expected first post to be unpinned, got %d", *pin)
+	}
+}
+
+func TestPublishTargets(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	mirror := writeastest.NewServer()
+	defer mirror.Close()
+	const tokenEnv = "BLOGSYNC_TEST_MIRROR_TOKEN"
+	defer os.Unsetenv(tokenEnv)
+	os.Setenv(tokenEnv, mirror.AddUser("me", "pass"))
+	mirrorAPI := newAPIClient(writeas.Config{URL: mirror.APIURL(), Token: os.Getenv(tokenEnv)}, log.New(ioutil.Discard, "", 0))
+	err := mirrorAPI.do(http.MethodPost, "/collections", collectionUpdate{Alias: "copy"}, nil)
+	if err != nil {
+		t.Fatalf("error creating mirror collection: %v", err)
+	}
+	const mainEnv = "BLOGSYNC_TEST_MAIN_TOKEN"
+	defer os.Unsetenv(mainEnv)
+	os.Setenv(mainEnv, pt.api.token)
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	pt.config.Targets = []Target{
+		{Name: "main", URL: pt.srv.APIURL(), TokenEnv: mainEnv},
+		{Name: "mirror", URL: mirror.APIURL(), TokenEnv: tokenEnv, Collections: map[string]string{"blog": "copy"}},
+	}
+	opts := newPublishOpts(pt.config)
+	opts.unlistedFile = pt.dir + ".unlisted.json"
+	discard := log.New(ioutil.Discard, "", 0)
+
+	published, err := publishTargets(opts, "mirror", pt.config, writeas.Config{}, 0, discard, discard)
+	if err != nil {
+		t.Fatalf("error publishing to mirror: %v", err)
+	}
+	if len(pt.srv.Posts()) != 0 {
+		t.Errorf("expected only the selected target to be published to, found %d posts on main", len(pt.srv.Posts()))
+	}
+	mirrorPosts := mirror.Posts()
+	if len(mirrorPosts) != 1 || mirrorPosts[0].Collection == nil || mirrorPosts[0].Collection.Alias != "copy" {
+		t.Errorf("expected post in the mapped collection, got %+v", mirrorPosts)
+	}
+	if want := map[string]bool{filepath.Join(pt.dir, "first.md"): true}; !reflect.DeepEqual(published, want) {
+		t.Errorf("wrong published pages: want=%v, got=%v", want, published)
+	}
+
+	// Pages are only reported as published if every target succeeded.
+	pt.config.Targets = append(pt.config.Targets, Target{Name: "broken"})
+	published, err = publishTargets(opts, "", pt.config, writeas.Config{}, 0, discard, discard)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("expected broken target to be reported, got %v", err)
+	}
+	if len(published) != 0 {
+		t.Errorf("expected no pages to be published to every target, got %v", published)
+	}
+	if want := []string{"blog/first"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts on main: want=%q, got=%q", want, keys(pt.posts()))
+	}
+
+	_, err = publishTargets(opts, "missing", pt.config, writeas.Config{}, 0, discard, discard)
+	if err == nil {
+		t.Errorf("expected an error selecting a missing target")
+	}
+	pt.config.Targets = nil
+	_, err = publishTargets(opts, "main", pt.config, writeas.Config{}, 0, discard, discard)
+	if err == nil {
+		t.Errorf("expected an error using --target without targets")
+	}
+}
+
+// Slugs generated for titles in other scripts must match the slug the server
+// gives the post, otherwise every publish creates a new post.
+func TestPublishTransliteratedSlugs(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	pt.write("ru.md", `title = "Привет, мир"`, "Hello")
+	pt.write("ja.md", `title = "日本語"`, "World")
+	pt.publish(nil)
+	before := pt.posts()
+	if want := []string{"blog/privet-mir", "blog/ri-ben-yu"}; !reflect.DeepEqual(keys(before), want) {
+		t.Fatalf("wrong posts: want=%q, got=%q", want, keys(before))
+	}
+
+	pt.write("ru.md", `title = "Привет, мир"`, "Hello again")
+	pt.publish(nil)
+	after := pt.posts()
+	if !reflect.DeepEqual(keys(after), keys(before)) {
+		t.Fatalf("wrong posts after publishing again: want=%q, got=%q", keys(before), keys(after))
+	}
+	for _, key := range keys(after) {
+		if after[key].ID != before[key].ID {
+			t.Errorf("post %s was recreated: want ID %q, got=%q", key, before[key].ID, after[key].ID)
+		}
+	}
+}
+
+func TestPublishCreateCollections(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	discard := log.New(ioutil.Discard, "", 0)
+
+	pt.write("news.md", "title = \"News\"\ncollection = \"news\"", "Hello")
+	pt.publish(func(opts *publishOptions) {
+		opts.createCollections = true
+	})
+	if want := []string{"news/news"}; !reflect.DeepEqual(keys(pt.posts()), want) {
+		t.Errorf("wrong posts: want=%q, got=%q", want, keys(pt.posts()))
+	}
+
+	// A collection that is owned by someone else cannot be created, which must
+	// be reported instead of publishing the post somewhere else.
+	other := writeas.NewClientWith(writeas.Config{URL: pt.srv.APIURL(), Token: pt.srv.AddUser("other", "pass")})
+	_, err := other.CreateCollection(&writeas.CollectionParams{Alias: "taken", Title: "Taken"})
+	if err != nil {
+		t.Fatalf("error creating collection: %v", err)
+	}
+	pt.write("taken.md", "title = \"Taken\"\ncollection = \"taken\"", "World")
+	opts := newPublishOpts(pt.config)
+	opts.createCollections = true
+	opts.unlistedFile = pt.dir + ".unlisted.json"
+	_, _, _, err = publish(opts, pt.config, pt.client, discard, discard)
+	if err == nil || !strings.Contains(err.Error(), "error creating collection taken") {
+		t.Errorf("wrong error creating a collection owned by someone else: %v", err)
+	}
+}
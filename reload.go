@@ -0,0 +1,173 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// reloadPath is the path of the Server-Sent Events endpoint that tells preview
+// pages to navigate to a post after it changes.
+const reloadPath = "/_blogsync/reload"
+
+// reloadCurrent is sent instead of a URL to reload whatever page is being
+// shown, for example after every post has been published again.
+const reloadCurrent = "reload"
+
+// reloadScript is injected into every HTML page served during preview.
+// When the server sends the URL of a changed post the page navigates to it,
+// reloading it if it is already being shown.
+const reloadScript = `<script>
+(function() {
+	if (!window.EventSource) {
+		return;
+	}
+	var events = new EventSource("` + reloadPath + `");
+	events.onmessage = function(e) {
+		if (e.data === "` + reloadCurrent + `" || e.data === window.location.href) {
+			window.location.reload();
+		} else {
+			window.location.href = e.data;
+		}
+	};
+})();
+</script>
+`
+
+// liveReload tells browsers viewing the preview about changed posts.
+type liveReload struct {
+	mu      sync.Mutex
+	clients map[chan string]struct{}
+}
+
+func newLiveReload() *liveReload {
+	return &liveReload{
+		clients: make(map[chan string]struct{}),
+	}
+}
+
+// notify sends the URL of a changed post, or reloadCurrent, to every connected
+// browser.
+func (lr *liveReload) notify(url string) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	for c := range lr.clients {
+		select {
+		case c <- url:
+		default:
+			// Don't block on slow clients, they'll catch up on the next change.
+		}
+	}
+}
+
+// handler serves the events endpoint and injects the reload script into HTML
+// pages served by next.
+func (lr *liveReload) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == reloadPath {
+			lr.serveEvents(w, r)
+			return
+		}
+
+		// Make sure that we can read the body if it's being proxied.
+		r.Header.Del("Accept-Encoding")
+		rec := &injectWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		rec.flush()
+	})
+}
+
+func (lr *liveReload) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c := make(chan string, 1)
+	lr.mu.Lock()
+	lr.clients[c] = struct{}{}
+	lr.mu.Unlock()
+	defer func() {
+		lr.mu.Lock()
+		delete(lr.clients, c)
+		lr.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case url := <-c:
+			_, err := fmt.Fprintf(w, "data: %s\n\n", url)
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// injectWriter buffers HTML responses so that the reload script can be added
+// before they are written.
+// Other responses are passed through unchanged.
+type injectWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         bytes.Buffer
+	wroteHeader bool
+	html        bool
+}
+
+func (w *injectWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.html = strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") && w.Header().Get("Content-Encoding") == ""
+	if !w.html {
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *injectWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.html {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// flush writes any buffered HTML with the reload script added.
+func (w *injectWriter) flush() {
+	if !w.html {
+		return
+	}
+	body := w.buf.Bytes()
+	if i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>")); i != -1 {
+		body = append(body[:i:i], append([]byte(reloadScript), body[i:]...)...)
+	} else {
+		body = append(body, reloadScript...)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.status)
+	/* #nosec */
+	w.ResponseWriter.Write(body)
+}
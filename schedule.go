@@ -0,0 +1,317 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/writeas/go-writeas/v2"
+	"mellium.im/blogsync/internal/blog"
+	"mellium.im/cli"
+)
+
+// scheduleRecheck is how often the schedule daemon looks for newly scheduled
+// pages while it waits.
+const scheduleRecheck = time.Minute
+
+// scheduledPage is a page that will not be published until its publish date.
+type scheduledPage struct {
+	Path       string    `json:"path"`
+	Title      string    `json:"title"`
+	Collection string    `json:"collection"`
+	Slug       string    `json:"slug"`
+	Date       time.Time `json:"date"`
+}
+
+// scheduledPages returns the pages with a publish date after now, sorted by
+// date.
+// Drafts and pages without a title are never published, so they are not
+// included.
+func scheduledPages(opts publishOptions, siteConfig Config, now time.Time, debug *log.Logger) ([]scheduledPage, error) {
+	sites, err := languageSites(opts, siteConfig)
+	if err != nil {
+		return nil, err
+	}
+	pages, err := buildPageIndex(sites, debug)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := make([]scheduledPage, 0)
+	for _, site := range sites {
+		err = site.opts.walkPages(func(pagePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			meta := make(blog.Metadata)
+			err = decodeMeta(pagePath, meta, debug)
+			if err != nil {
+				debug.Printf("error decoding metadata for %s, not checking its date: %v", pagePath, err)
+				return nil
+			}
+			title := meta.GetString("title")
+			if meta.GetBool("draft") || title == "" {
+				return nil
+			}
+			loc, err := meta.Location(site.config.location)
+			if err != nil {
+				debug.Printf("invalid time zone in %s, using %s instead: %v", pagePath, loc, err)
+			}
+			date := timeOrDef(meta.GetTimeIn("publishDate", loc), meta.GetTimeIn("date", loc))
+			if !date.After(now) {
+				return nil
+			}
+			idx := pages[pagePath]
+			scheduled = append(scheduled, scheduledPage{
+				Path:       pagePath,
+				Title:      title,
+				Collection: orDef(idx.collection, site.opts.collection),
+				Slug:       idx.slug,
+				Date:       date,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return scheduled[i].Date.Before(scheduled[j].Date)
+	})
+	return scheduled, nil
+}
+
+// heldPost is a remote post for a page that is being held back until its
+// publish date.
+type heldPost struct {
+	writeas.Post
+	path string
+	date time.Time
+}
+
+// splitHeldBack removes the posts for pages with a publish date after now from
+// posts and returns them separately.
+// Drafts are not held back, they are treated as though they have no page.
+// Posts are matched to pages by their collection and slug, and posts that are
+// not in a collection by their slug alone.
+func splitHeldBack(posts []writeas.Post, pages pageIndex, opts publishOptions, now time.Time) ([]writeas.Post, []heldPost) {
+	bySlug := make(map[slugKey]string)
+	for pagePath, page := range pages {
+		if page.draft || !page.date.After(now) {
+			continue
+		}
+		bySlug[slugKey{collection: opts.remoteCollection(page.collection), slug: page.slug}] = pagePath
+	}
+	if len(bySlug) == 0 {
+		return posts, nil
+	}
+
+	remaining := make([]writeas.Post, 0, len(posts))
+	var held []heldPost
+	for _, post := range posts {
+		key := slugKey{slug: post.Slug}
+		if post.Collection != nil {
+			key.collection = post.Collection.Alias
+		}
+		pagePath, ok := bySlug[key]
+		if !ok {
+			remaining = append(remaining, post)
+			continue
+		}
+		held = append(held, heldPost{
+			Post: post,
+			path: pagePath,
+			date: pages[pagePath].date,
+		})
+	}
+	return remaining, held
+}
+
+func scheduleCmd(siteConfig Config, clientConfig writeas.Config, torPort int, logger, debug *log.Logger) *cli.Command {
+	opts := newPublishOpts(siteConfig)
+	var (
+		asJSON = false
+		daemon = false
+		target = ""
+	)
+
+	flags := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	flags.BoolVar(&asJSON, "json", asJSON, "Print the scheduled pages as JSON")
+	flags.BoolVar(&daemon, "daemon", daemon, "Keep running and publish each page when its publish date arrives")
+	flags.StringVar(&opts.content, "content", opts.content, "A directory containing pages")
+	flags.StringVar(&target, "target", target, "With --daemon, a comma separated list of targets from the config file to publish to (defaults to all targets)")
+
+	return &cli.Command{
+		Usage: "schedule [options]",
+		Flags: flags,
+		Description: `List pages that are waiting for their publish date.
+
+Pages with a publishDate (or date) in the future are held back by the publish
+command until that date has passed.
+With --daemon, the site is published and then the command keeps running,
+publishing it again whenever the publish date of a page arrives.
+If publishing fails, it is retried with an increasing delay until every page
+whose date has arrived has been published.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if !daemon {
+				scheduled, err := scheduledPages(opts, siteConfig, time.Now(), debug)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return printJSON(scheduled)
+				}
+				w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+				fmt.Fprintln(w, "DATE\tCOLLECTION\tSLUG\tTITLE\tFILE")
+				for _, p := range scheduled {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.Date.Format(time.RFC3339), p.Collection, p.Slug, p.Title, p.Path)
+				}
+				return w.Flush()
+			}
+
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt)
+			return scheduleDaemon(opts, target, siteConfig, clientConfig, torPort, sigs, logger, debug)
+		},
+	}
+}
+
+// scheduleDaemon publishes the site and then publishes it again whenever the
+// publish date of a page arrives, until a value is received on stop.
+// Pages stay pending once their publish date arrives until they have been
+// published to every target, and publishing is retried with an increasing delay
+// while any of them fail.
+func scheduleDaemon(opts publishOptions, target string, siteConfig Config, clientConfig writeas.Config, torPort int, stop <-chan os.Signal, logger, debug *log.Logger) error {
+	_, err := publishTargets(opts, target, siteConfig, clientConfig, torPort, logger, debug)
+	if err != nil {
+		logger.Print(err)
+	}
+
+	pending := make(map[string]bool)
+	var failures int
+	var retryAt time.Time
+	for {
+		now := time.Now()
+		scheduled, err := scheduledPages(opts, siteConfig, now, debug)
+		if err != nil {
+			return err
+		}
+		// Check for new pages every so often so that pages scheduled while we
+		// are waiting are not missed.
+		wait := scheduleRecheck
+		if len(scheduled) > 0 {
+			next := scheduled[0]
+			debug.Printf("next page to publish is %s at %s", next.Path, next.Date)
+			if until := next.Date.Sub(now); until < wait {
+				wait = until
+			}
+		}
+		if len(pending) > 0 {
+			if until := retryAt.Sub(now); until < wait {
+				wait = until
+			}
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(wait):
+		}
+
+		// Every scheduled page with a date that has passed is due, not just the
+		// one we were waiting for, in case several pages have the same date or
+		// the timer fired late.
+		now = time.Now()
+		for _, page := range scheduled {
+			if !page.Date.After(now) {
+				pending[page.Path] = true
+			}
+		}
+		if len(pending) == 0 || now.Before(retryAt) {
+			continue
+		}
+
+		logger.Printf("publish date of %s has arrived, publishing…", strings.Join(sortedKeys(pending), ", "))
+		published, err := publishTargets(opts, target, siteConfig, clientConfig, torPort, logger, debug)
+		if err != nil {
+			logger.Print(err)
+		}
+		for pagePath := range published {
+			delete(pending, pagePath)
+		}
+		dropUnpublishable(pending, opts, siteConfig, debug)
+		if len(pending) == 0 {
+			failures = 0
+			retryAt = time.Time{}
+			continue
+		}
+		failures++
+		delay := retryDelay(failures)
+		retryAt = time.Now().Add(delay)
+		logger.Printf("%s could not be published, retrying in %s", strings.Join(sortedKeys(pending), ", "), delay)
+	}
+}
+
+// Limits on how long scheduleDaemon waits before trying to publish pages again
+// after publishing them fails.
+const (
+	scheduleRetryMin = 15 * time.Second
+	scheduleRetryMax = 30 * time.Minute
+)
+
+// retryDelay returns how long to wait before trying to publish again after the
+// given number of consecutive failures, doubling with each failure.
+func retryDelay(failures int) time.Duration {
+	delay := scheduleRetryMin
+	for i := 1; i < failures && delay < scheduleRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > scheduleRetryMax {
+		delay = scheduleRetryMax
+	}
+	return delay
+}
+
+// dropUnpublishable removes pages from pending that were deleted, made drafts,
+// or had their dates changed since they became pending, so that they are not
+// retried forever.
+func dropUnpublishable(pending map[string]bool, opts publishOptions, siteConfig Config, debug *log.Logger) {
+	if len(pending) == 0 {
+		return
+	}
+	sites, err := languageSites(opts, siteConfig)
+	if err != nil {
+		debug.Printf("error loading sites to check pending pages: %v", err)
+		return
+	}
+	pages, err := buildPageIndex(sites, debug)
+	if err != nil {
+		debug.Printf("error indexing pages to check pending pages: %v", err)
+		return
+	}
+	for pagePath := range pending {
+		if page, ok := pages[pagePath]; !ok || !page.published {
+			debug.Printf("%s will no longer be published, not retrying it", pagePath)
+			delete(pending, pagePath)
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
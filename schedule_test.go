@@ -0,0 +1,101 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/writeas/go-writeas/v2"
+)
+
+var retryDelayTestCases = [...]struct {
+	failures int
+	delay    time.Duration
+}{
+	0: {failures: 1, delay: scheduleRetryMin},
+	1: {failures: 2, delay: 2 * scheduleRetryMin},
+	2: {failures: 3, delay: 4 * scheduleRetryMin},
+	3: {failures: 100, delay: scheduleRetryMax},
+}
+
+func TestRetryDelay(t *testing.T) {
+	for i, tc := range retryDelayTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if delay := retryDelay(tc.failures); delay != tc.delay {
+				t.Errorf("wrong delay after %d failures: want=%s, got=%s", tc.failures, tc.delay, delay)
+			}
+		})
+	}
+}
+
+func TestSplitHeldBack(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	pages := pageIndex{
+		"blog.md": {collection: "blog", slug: "held", date: future},
+		"anon.md": {slug: "anon", date: future},
+		"past.md": {collection: "blog", slug: "past", date: now.Add(-time.Hour)},
+	}
+	posts := []writeas.Post{
+		{ID: "1", Slug: "held", Collection: &writeas.Collection{Alias: "blog"}},
+		{ID: "2", Slug: "anon"},
+		{ID: "3", Slug: "past", Collection: &writeas.Collection{Alias: "blog"}},
+		{ID: "4", Slug: "held"},
+	}
+	remaining, held := splitHeldBack(posts, pages, publishOptions{}, now)
+	heldIDs := make(map[string]string)
+	for _, post := range held {
+		heldIDs[post.ID] = post.path
+	}
+	if want := map[string]string{"1": "blog.md", "2": "anon.md"}; !reflect.DeepEqual(heldIDs, want) {
+		t.Errorf("wrong held back posts: want=%v, got=%v", want, heldIDs)
+	}
+	var remainingIDs []string
+	for _, post := range remaining {
+		remainingIDs = append(remainingIDs, post.ID)
+	}
+	if want := []string{"3", "4"}; !reflect.DeepEqual(remainingIDs, want) {
+		t.Errorf("wrong remaining posts: want=%q, got=%q", want, remainingIDs)
+	}
+}
+
+func TestScheduleDaemon(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+
+	date := time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	pt.write("now.md", `title = "Now"`, "Hello")
+	pt.write("soon.md", "title = \"Soon\"\npublishDate = "+date, "Hello")
+	pt.write("later.md", "title = \"Later\"\npublishDate = "+date, "Hello")
+	pt.write("never.md", "title = \"Never\"\npublishDate = 2999-01-01T00:00:00Z", "Hello")
+
+	stop := make(chan os.Signal)
+	done := make(chan error, 1)
+	go func() {
+		opts := newPublishOpts(pt.config)
+		discard := log.New(ioutil.Discard, "", 0)
+		clientConfig := writeas.Config{URL: pt.srv.APIURL(), Token: pt.client.Token()}
+		done <- scheduleDaemon(opts, "", pt.config, clientConfig, 0, stop, discard, discard)
+	}()
+
+	want := []string{"blog/later", "blog/now", "blog/soon"}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) && len(pt.posts()) < len(want) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	stop <- os.Interrupt
+	if err := <-done; err != nil {
+		t.Errorf("error running daemon: %v", err)
+	}
+	if posts := keys(pt.posts()); !reflect.DeepEqual(posts, want) {
+		t.Errorf("wrong posts: want=%q, got=%q", want, posts)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/russross/blackfriday/v2"
+)
+
+// anonAlias is used in place of the collection alias in the URLs of posts that
+// are not in a collection.
+const anonAlias = "_"
+
+// previewPost is a post being served by the native preview server.
+type previewPost struct {
+	Path       string
+	Collection string
+	Slug       string
+	Title      string
+	Created    time.Time
+	Lang       string
+	RTL        bool
+	Font       string
+	Pinned     int
+	Body       template.HTML
+
+	// Notices explain why a post that would not normally be published, such as
+	// a draft, is being shown.
+	Notices []string
+}
+
+// URL returns the path of the post on the preview server.
+func (p previewPost) URL() string {
+	return "/" + orDef(p.Collection, anonAlias) + "/" + p.Slug
+}
+
+// previewCollection is a collection being served by the native preview server.
+type previewCollection struct {
+	Alias       string
+	Title       string
+	Description string
+	StyleSheet  template.CSS
+	Posts       []previewPost
+}
+
+// URL returns the path of the collection on the preview server.
+func (c previewCollection) URL() string {
+	return "/" + orDef(c.Alias, anonAlias) + "/"
+}
+
+// previewServer renders pages and serves them in a layout similar to
+// write.as without needing a WriteFreely install.
+type previewServer struct {
+	baseAddr     string
+	siteConfig   Config
+	compiledTmpl *texttemplate.Template
+	logger       *log.Logger
+	debug        *log.Logger
+
+	mu    sync.RWMutex
+	colls map[string]previewCollection
+	posts map[string]previewPost
+}
+
+func newPreviewServer(baseAddr string, logger, debug *log.Logger) *previewServer {
+	return &previewServer{
+		baseAddr: baseAddr,
+		logger:   logger,
+		debug:    debug,
+		colls:    make(map[string]previewCollection),
+		posts:    make(map[string]previewPost),
+	}
+}
+
+// republish replaces every post and collection being served with those
+// rendered from the pages in opts using the current template and config.
+// If the template or site config is invalid, or any page cannot be read,
+// nothing is changed.
+func (s *previewServer) republish(opts publishOptions, siteConfig Config) error {
+	compiledTmpl, err := compileTmpl(opts.tmpl)
+	if err != nil {
+		return err
+	}
+	sites, err := languageSites(opts, siteConfig)
+	if err != nil {
+		return err
+	}
+	pages, err := buildPageIndex(sites, s.debug)
+	if err != nil {
+		return err
+	}
+
+	// Render into a new server so that the old posts are served until every
+	// page has been published.
+	next := &previewServer{
+		baseAddr:     s.baseAddr,
+		siteConfig:   siteConfig,
+		compiledTmpl: compiledTmpl,
+		logger:       s.logger,
+		debug:        s.debug,
+		colls:        make(map[string]previewCollection),
+		posts:        make(map[string]previewPost),
+	}
+	for _, site := range sites {
+		site.opts.pages = pages
+		next.addCollection(site.config.Collection, site)
+		err = site.opts.walkPages(func(pagePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			_, err = next.publishPage(pagePath, site)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.siteConfig = siteConfig
+	s.compiledTmpl = compiledTmpl
+	s.colls = next.colls
+	s.posts = next.posts
+	return nil
+}
+
+func (s *previewServer) baseURL() string {
+	return s.baseAddr
+}
+
+// addCollection adds a collection to the server if it does not already exist.
+// If the collection is the default collection for a site its title and
+// description are taken from the site config.
+func (s *previewServer) addCollection(alias string, site langSite) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addCollectionLocked(alias, site)
+}
+
+func (s *previewServer) addCollectionLocked(alias string, site langSite) {
+	if _, ok := s.colls[alias]; ok {
+		return
+	}
+	collConfig, err := s.siteConfig.Collections[alias].loadStyleSheet(alias)
+	if err != nil {
+		s.logger.Print(err)
+	}
+	coll := previewCollection{
+		Alias:       alias,
+		Title:       orDef(collConfig.Title, orDef(alias, "Anonymous posts")),
+		Description: collConfig.Description,
+		/* #nosec */
+		StyleSheet: template.CSS(collConfig.StyleSheet),
+	}
+	if alias == site.config.Collection {
+		coll.Title = orDef(collConfig.Title, orDef(site.config.Title, coll.Title))
+		coll.Description = orDef(collConfig.Description, site.config.Description)
+	}
+	s.colls[alias] = coll
+}
+
+// publishPage renders the page at pagePath and serves it, replacing any
+// previous version of the page.
+// If the page should not be published an empty URL is returned.
+func (s *previewServer) publishPage(pagePath string, site langSite) (string, error) {
+	rendered := renderPost(pagePath, site.opts, site.config, s.compiledTmpl, s.logger, s.debug)
+	if rendered == nil {
+		return "", s.removePage(pagePath)
+	}
+	params := rendered.params
+
+	body := blackfriday.Run([]byte(params.Content),
+		blackfriday.WithExtensions(blackfriday.CommonExtensions|blackfriday.Footnotes),
+	)
+	post := previewPost{
+		Path:       pagePath,
+		Collection: params.Collection,
+		Slug:       params.Slug,
+		Title:      params.Title,
+		Font:       params.Font,
+		/* #nosec */
+		Body:    template.HTML(body),
+		Notices: rendered.notices(),
+	}
+	if params.Created != nil {
+		post.Created = *params.Created
+	}
+	if params.Language != nil {
+		post.Lang = *params.Language
+	}
+	if params.IsRTL != nil {
+		post.RTL = *params.IsRTL
+	}
+	if pin, ok := rendered.meta["pin"].(int64); ok {
+		post.Pinned = int(pin)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addCollectionLocked(post.Collection, site)
+	for otherPath, other := range s.posts {
+		if otherPath != pagePath && other.Collection == post.Collection && other.Slug == post.Slug {
+			s.logger.Printf("post %s from %s is being replaced by %s", post.URL(), otherPath, pagePath)
+			delete(s.posts, otherPath)
+		}
+	}
+	s.posts[pagePath] = post
+	s.debug.Printf("serving %s at %s", pagePath, post.URL())
+	return s.baseAddr + post.URL(), nil
+}
+
+// removePage stops serving the page published from pagePath.
+func (s *previewServer) removePage(pagePath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.posts, pagePath)
+	return nil
+}
+
+// pagePaths returns the paths of all pages being served.
+func (s *previewServer) pagePaths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	paths := make([]string, 0, len(s.posts))
+	for pagePath := range s.posts {
+		paths = append(paths, pagePath)
+	}
+	return paths
+}
+
+// collection returns the collection with the given alias and its posts sorted
+// with pinned posts first and then by date, newest first.
+func (s *previewServer) collection(alias string) (previewCollection, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	coll, ok := s.colls[alias]
+	if !ok {
+		return coll, false
+	}
+	coll.Posts = nil
+	for _, post := range s.posts {
+		if post.Collection == alias {
+			coll.Posts = append(coll.Posts, post)
+		}
+	}
+	sort.Slice(coll.Posts, func(i, j int) bool {
+		pi, pj := coll.Posts[i], coll.Posts[j]
+		switch {
+		case pi.Pinned > 0 && pj.Pinned > 0 && pi.Pinned != pj.Pinned:
+			return pi.Pinned < pj.Pinned
+		case (pi.Pinned > 0) != (pj.Pinned > 0):
+			return pi.Pinned > 0
+		case !pi.Created.Equal(pj.Created):
+			return pi.Created.After(pj.Created)
+		}
+		return pi.Slug < pj.Slug
+	})
+	return coll, true
+}
+
+// collections returns the aliases of every collection, sorted.
+func (s *previewServer) collections() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	aliases := make([]string, 0, len(s.colls))
+	for alias := range s.colls {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// ServeHTTP serves an index of collections at "/", the posts in a collection at
+// "/<alias>/", and posts at "/<alias>/<slug>".
+// Posts that are not in a collection are served under the alias anonAlias.
+func (s *previewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.Trim(path.Clean(r.URL.Path), "/")
+	parts := strings.SplitN(p, "/", 2)
+	if parts[0] == anonAlias {
+		parts[0] = ""
+	}
+	switch {
+	case p == "":
+		s.mu.RLock()
+		title := orDef(s.siteConfig.Title, "Preview")
+		s.mu.RUnlock()
+		aliases := s.collections()
+		if len(aliases) == 1 && aliases[0] != "" {
+			http.Redirect(w, r, "/"+aliases[0]+"/", http.StatusFound)
+			return
+		}
+		var colls []previewCollection
+		for _, alias := range aliases {
+			coll, _ := s.collection(alias)
+			colls = append(colls, coll)
+		}
+		s.render(w, "index", struct {
+			Title       string
+			Collections []previewCollection
+		}{
+			Title:       title,
+			Collections: colls,
+		})
+	case len(parts) == 1:
+		coll, ok := s.collection(parts[0])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.render(w, "collection", coll)
+	default:
+		coll, ok := s.collection(parts[0])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for _, post := range coll.Posts {
+			if post.Slug == parts[1] {
+				s.render(w, "post", struct {
+					Collection previewCollection
+					Post       previewPost
+				}{
+					Collection: coll,
+					Post:       post,
+				})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+func (s *previewServer) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := previewTmpl.ExecuteTemplate(w, name, data)
+	if err != nil {
+		s.logger.Printf("error rendering preview page %s: %v", name, err)
+	}
+}
+
+var previewTmpl = template.Must(template.New("preview").Parse(`
+{{- define "head" -}}
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { margin: 0 auto; max-width: 40em; padding: 1em; font-family: Lora, Georgia, serif; line-height: 1.6; color: #333; }
+body.sans { font-family: "Open Sans", Helvetica, Arial, sans-serif; }
+body.wrap, body.mono { font-family: Hack, Menlo, Consolas, monospace; }
+header { margin: 2em 0; }
+header h1 a, .post-title a { color: inherit; text-decoration: none; }
+header p { color: #777; }
+time { color: #777; font-size: 0.9em; }
+article { margin-bottom: 3em; }
+pre { overflow-x: auto; }
+img { max-width: 100%; }
+.pinned { font-size: 0.8em; color: #777; }
+.notice { border: 2px solid #c60; background: #fff3e0; color: #333; padding: 0.5em 1em; margin-bottom: 1em; }
+.notice p { margin: 0.25em 0; }
+.notice-label { font-size: 0.8em; color: #c60; font-weight: bold; }
+</style>
+{{- end -}}
+
+{{- define "index" -}}
+{{template "head" .Title}}
+</head>
+<body>
+<header><h1>{{.Title}}</h1></header>
+<ul>
+{{range .Collections}}<li><a href="{{.URL}}">{{.Title}}</a> ({{len .Posts}} posts)</li>
+{{end}}</ul>
+</body>
+</html>
+{{- end -}}
+
+{{- define "collection" -}}
+{{template "head" .Title}}
+{{with .StyleSheet}}<style>{{.}}</style>{{end}}
+</head>
+<body id="collection">
+<header>
+<h1><a href="{{.URL}}">{{.Title}}</a></h1>
+{{with .Description}}<p>{{.}}</p>{{end}}
+</header>
+{{range .Posts}}
+<article class="post">
+<h2 class="post-title"><a href="{{.URL}}">{{.Title}}</a></h2>
+{{if .Pinned}}<span class="pinned">Pinned</span>{{else if not .Created.IsZero}}<time datetime="{{.Created.Format "2006-01-02T15:04:05Z07:00"}}">{{.Created.Format "January 2, 2006"}}</time>{{end}}
+{{if .Notices}}<span class="notice-label">Preview only</span>{{end}}
+</article>
+{{else}}
+<p>There are no posts in this collection yet.</p>
+{{end}}
+</body>
+</html>
+{{- end -}}
+
+{{- define "post" -}}
+{{template "head" .Post.Title}}
+{{with .Collection.StyleSheet}}<style>{{.}}</style>{{end}}
+</head>
+<body id="post" class="{{.Post.Font}}">
+<header><p><a href="{{.Collection.URL}}">{{.Collection.Title}}</a></p></header>
+{{with .Post.Notices}}<div class="notice">{{range .}}<p><strong>Preview:</strong> {{.}}</p>{{end}}</div>{{end}}
+<article{{with .Post.Lang}} lang="{{.}}"{{end}}{{if .Post.RTL}} dir="rtl"{{end}}>
+<h1>{{.Post.Title}}</h1>
+{{if not .Post.Created.IsZero}}<time datetime="{{.Post.Created.Format "2006-01-02T15:04:05Z07:00"}}">{{.Post.Created.Format "January 2, 2006"}}</time>{{end}}
+{{.Post.Body}}
+</article>
+</body>
+</html>
+{{- end -}}
+`))
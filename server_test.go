@@ -0,0 +1,91 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var previewServerTestCases = [...]struct {
+	colls    []string
+	path     string
+	code     int
+	location string
+	body     string
+}{
+	0: {colls: []string{"blog"}, path: "/", code: http.StatusFound, location: "/blog/"},
+	1: {colls: []string{"blog"}, path: "/blog/", code: http.StatusOK, body: `href="/blog/named"`},
+	2: {colls: []string{"blog"}, path: "/blog/named", code: http.StatusOK, body: "Named Post"},
+	3: {colls: []string{"blog"}, path: "/blog/missing", code: http.StatusNotFound},
+	4: {colls: []string{""}, path: "/", code: http.StatusOK, body: `href="/_/"`},
+	5: {colls: []string{""}, path: "/_/", code: http.StatusOK, body: `href="/_/anon"`},
+	6: {colls: []string{""}, path: "/_/anon", code: http.StatusOK, body: "Anonymous Post"},
+	7: {colls: []string{"", "blog"}, path: "/", code: http.StatusOK, body: `href="/blog/"`},
+	8: {colls: []string{"blog"}, path: "/_/anon", code: http.StatusNotFound},
+}
+
+func TestPreviewServer(t *testing.T) {
+	for i, tc := range previewServerTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			discard := log.New(ioutil.Discard, "", 0)
+			s := newPreviewServer("http://localhost", discard, discard)
+			for _, alias := range tc.colls {
+				s.addCollection(alias, langSite{})
+			}
+			s.posts["named.md"] = previewPost{Path: "named.md", Collection: "blog", Slug: "named", Title: "Named Post"}
+			s.posts["anon.md"] = previewPost{Path: "anon.md", Slug: "anon", Title: "Anonymous Post"}
+
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+			if w.Code != tc.code {
+				t.Errorf("wrong status code for %s: want=%d, got=%d", tc.path, tc.code, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tc.location {
+				t.Errorf("wrong redirect for %s: want=%q, got=%q", tc.path, tc.location, location)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tc.body) {
+				t.Errorf("expected %s to contain %q, got %q", tc.path, tc.body, body)
+			}
+		})
+	}
+}
+
+// A republish that fails must leave the previous posts being served.
+func TestPreviewServerRepublishError(t *testing.T) {
+	pt := newPublishTest(t)
+	defer pt.close()
+	discard := log.New(ioutil.Discard, "", 0)
+	s := newPreviewServer("http://localhost", discard, discard)
+
+	pt.write("first.md", `title = "First"`, "Hello")
+	err := s.republish(newPublishOpts(pt.config), pt.config)
+	if err != nil {
+		t.Fatalf("error publishing: %v", err)
+	}
+	want := []string{filepath.Join(pt.dir, "first.md")}
+	if paths := s.pagePaths(); !reflect.DeepEqual(paths, want) {
+		t.Fatalf("wrong pages: want=%q, got=%q", want, paths)
+	}
+
+	pt.config.Content = filepath.Join(pt.dir, "missing")
+	err = s.republish(newPublishOpts(pt.config), pt.config)
+	if err == nil {
+		t.Fatalf("expected republishing a missing directory to fail")
+	}
+	if paths := s.pagePaths(); !reflect.DeepEqual(paths, want) {
+		t.Errorf("wrong pages after failed republish: want=%q, got=%q", want, paths)
+	}
+	if _, ok := s.collection("blog"); !ok {
+		t.Errorf("expected collection to still be served after failed republish")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"mellium.im/blogsync/internal/blog"
+)
+
+// Rules for handling pages that would be published with the same slug.
+const (
+	// disambiguateNone refuses to publish when any two pages in the same
+	// collection have the same slug.
+	disambiguateNone = ""
+
+	// disambiguateSuffix keeps the slug of the first page (in lexical order)
+	// and appends "-2", "-3", etc. to the slugs of any later pages.
+	disambiguateSuffix = "suffix"
+)
+
+// SlugConfig controls how slugs are generated and what happens when two pages
+// generate the same slug.
+type SlugConfig struct {
+	blog.Slugger
+
+	// Disambiguate is the rule used to resolve slug collisions, either empty
+	// to refuse to publish or "suffix" to append a number to later slugs.
+	Disambiguate string `toml:"Disambiguate"`
+}
+
+// pageIndex maps page paths to information about the page.
+type pageIndex map[string]indexedPage
+
+// indexedPage is a page with a title that may be published.
+type indexedPage struct {
+	collection     string
+	slug           string
+	title          string
+	lang           string
+	translationKey string
+
+	// published is false if the page is a draft, has a publish date in the
+	// future, or has expired, and the options used to build the index don't
+	// include such pages.
+	published bool
+	draft     bool
+	// date is the publish date of the page, or the zero time if it has none.
+	date time.Time
+	// expiry is the time after which the page should no longer be published,
+	// or the zero time if it does not expire.
+	expiry time.Time
+}
+
+// slugKey identifies a post on the remote server.
+type slugKey struct {
+	collection string
+	slug       string
+}
+
+// buildPageIndex walks the content directory of each language and generates a
+// slug for every page with a title.
+// Pages are filtered using the drafts, future, and expired options of each
+// site the same way that renderPost does, and only pages that will be
+// published are checked for collisions.
+// If any two of them in the same collection on the target end up with the same
+// slug and no disambiguation rule is configured, an error listing every
+// collision is returned.
+func buildPageIndex(sites []langSite, debug *log.Logger) (pageIndex, error) {
+	if len(sites) == 0 {
+		return pageIndex{}, nil
+	}
+	slugs := sites[0].config.Slugs
+	switch slugs.Disambiguate {
+	case disambiguateNone, disambiguateSuffix:
+	default:
+		return nil, fmt.Errorf("unknown slug disambiguation rule %q", slugs.Disambiguate)
+	}
+
+	var keys []slugKey
+	paths := make(map[slugKey][]string)
+	idx := make(pageIndex)
+	now := time.Now()
+	for _, site := range sites {
+		opts := site.opts
+		err := opts.walkPages(func(pagePath string, info os.FileInfo, err error) error {
+			meta := make(blog.Metadata)
+			err = decodeMeta(pagePath, meta, debug)
+			if err != nil {
+				// Errors will be reported when the page is published, for now just
+				// ignore pages that will be skipped anyways.
+				debug.Printf("error decoding metadata for %s, not indexing slug: %v", pagePath, err)
+				return nil
+			}
+			title := meta.GetString("title")
+			if title == "" {
+				return nil
+			}
+			loc, err := meta.Location(site.config.location)
+			if err != nil {
+				debug.Printf("invalid time zone in %s, using %s instead: %v", pagePath, loc, err)
+			}
+			date, expiry := pageDates(meta, loc)
+
+			collection := orDef(meta.GetString("collection"), opts.collection)
+			// Collisions are checked in the collection being published to, since
+			// several local collections may be mapped to the same one on a target.
+			key := slugKey{
+				collection: opts.remoteCollection(collection),
+				slug:       slugs.Slug(opts.content, pagePath, meta, site.config.Permalinks, loc),
+			}
+			draft := meta.GetBool("draft")
+			published := opts.publishes(draft, date, expiry, now)
+			if published {
+				if _, ok := paths[key]; !ok {
+					keys = append(keys, key)
+				}
+				paths[key] = append(paths[key], pagePath)
+			}
+			idx[pagePath] = indexedPage{
+				collection:     collection,
+				slug:           key.slug,
+				title:          title,
+				lang:           opts.lang,
+				translationKey: translationKey(opts, pagePath, meta),
+				published:      published,
+				draft:          draft,
+				date:           date,
+				expiry:         expiry,
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var collisions []string
+	for _, key := range keys {
+		pagePaths := paths[key]
+		if len(pagePaths) == 1 {
+			continue
+		}
+
+		if slugs.Disambiguate == disambiguateNone {
+			collisions = append(collisions, fmt.Sprintf("\t%s/%s: %s", key.collection, key.slug, strings.Join(pagePaths, ", ")))
+			continue
+		}
+
+		n := 2
+		for _, pagePath := range pagePaths[1:] {
+			var newKey slugKey
+			for {
+				newKey = slugKey{collection: key.collection, slug: key.slug + "-" + strconv.Itoa(n)}
+				n++
+				if _, ok := paths[newKey]; !ok {
+					break
+				}
+			}
+			paths[newKey] = []string{pagePath}
+			debug.Printf("slug %q for %s already in use by %s, using %q", key.slug, pagePath, pagePaths[0], newKey.slug)
+			page := idx[pagePath]
+			page.slug = newKey.slug
+			idx[pagePath] = page
+		}
+	}
+	if len(collisions) > 0 {
+		sort.Strings(collisions)
+		return nil, fmt.Errorf(`multiple pages would be published with the same slug:
+
+%s
+
+Set a unique slug in the frontmatter of each page or set Disambiguate in the
+Slugs section of the config file.`, strings.Join(collisions, "\n"))
+	}
+
+	return idx, nil
+}
+
+// collections returns the sorted aliases of every collection that pages will be
+// published to.
+func (idx pageIndex) collections() []string {
+	seen := make(map[string]bool)
+	var aliases []string
+	for _, page := range idx {
+		if page.published && page.collection != "" && !seen[page.collection] {
+			seen[page.collection] = true
+			aliases = append(aliases, page.collection)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// translations returns the other translations of the page at pagePath, sorted
+// by language.
+func (idx pageIndex) translations(pagePath string, opts publishOptions) []translation {
+	page, ok := idx[pagePath]
+	if !ok || page.lang == "" {
+		return []translation{}
+	}
+	trans := []translation{}
+	for otherPath, other := range idx {
+		if otherPath == pagePath || !other.published || other.lang == page.lang || other.translationKey != page.translationKey {
+			continue
+		}
+		trans = append(trans, translation{
+			Lang:         other.lang,
+			LanguageName: opts.languages[other.lang].LanguageName,
+			Title:        other.title,
+			Collection:   opts.remoteCollection(other.collection),
+			Slug:         other.slug,
+		})
+	}
+	sort.Slice(trans, func(i, j int) bool {
+		if trans[i].Lang != trans[j].Lang {
+			return trans[i].Lang < trans[j].Lang
+		}
+		return trans[i].Slug < trans[j].Slug
+	})
+	return trans
+}
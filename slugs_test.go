@@ -0,0 +1,199 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var pageIndexTestCases = [...]struct {
+	files        map[string]string
+	disambiguate string
+	drafts       bool
+	future       bool
+	expired      bool
+	permalinks   map[string]string
+	collections  map[string]string
+	slugs        map[string]string
+	err          string
+}{
+	0: {
+		files: map[string]string{
+			"a.md": `title = "A"`,
+			"b.md": `title = "B"`,
+		},
+		slugs: map[string]string{"a.md": "a", "b.md": "b"},
+	},
+	1: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": `title = "Same"`,
+		},
+		err: "blog/same: a.md, b.md",
+	},
+	2: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": `title = "Same"`,
+			"c.md": `title = "Same"`,
+		},
+		disambiguate: disambiguateSuffix,
+		slugs:        map[string]string{"a.md": "same", "b.md": "same-2", "c.md": "same-3"},
+	},
+	3: {
+		// Pages in different collections may use the same slug.
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\ncollection = \"other\"",
+		},
+		slugs: map[string]string{"a.md": "same", "b.md": "same"},
+	},
+	4: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\ndraft = true",
+		},
+		slugs: map[string]string{"a.md": "same"},
+	},
+	5: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\ndraft = true",
+		},
+		drafts: true,
+		err:    "blog/same: a.md, b.md",
+	},
+	6: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\npublishDate = 2999-01-01T00:00:00Z",
+		},
+		slugs: map[string]string{"a.md": "same"},
+	},
+	7: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\npublishDate = 2999-01-01T00:00:00Z",
+		},
+		future:       true,
+		disambiguate: disambiguateSuffix,
+		slugs:        map[string]string{"a.md": "same", "b.md": "same-2"},
+	},
+	8: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\nexpiryDate = 2000-01-01T00:00:00Z",
+		},
+		slugs: map[string]string{"a.md": "same"},
+	},
+	9: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\nexpiryDate = 2000-01-01T00:00:00Z",
+		},
+		expired: true,
+		err:     "blog/same: a.md, b.md",
+	},
+	10: {
+		// Pages without a title are never published.
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": `slug = "same"`,
+		},
+		slugs: map[string]string{"a.md": "same"},
+	},
+	11: {
+		files:        map[string]string{"a.md": `title = "A"`},
+		disambiguate: "unknown",
+		err:          `unknown slug disambiguation rule "unknown"`,
+	},
+	12: {
+		// Permalink dates are expanded in the time zone of the page.
+		files: map[string]string{
+			"posts/a.md": "title = \"A\"\ntimeZone = \"Asia/Tokyo\"\ndate = 2020-01-01T05:00:00",
+		},
+		permalinks: map[string]string{"posts": ":year-:month-:day-:title"},
+		slugs:      map[string]string{"a.md": "2020-01-01-a"},
+	},
+	13: {
+		// Collections mapped to the same collection on the target share slugs.
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\ncollection = \"other\"",
+		},
+		collections: map[string]string{"other": "blog"},
+		err:         "blog/same: a.md, b.md",
+	},
+	14: {
+		files: map[string]string{
+			"a.md": `title = "Same"`,
+			"b.md": "title = \"Same\"\ncollection = \"other\"",
+		},
+		collections:  map[string]string{"other": "blog"},
+		disambiguate: disambiguateSuffix,
+		slugs:        map[string]string{"a.md": "same", "b.md": "same-2"},
+	},
+}
+
+func TestBuildPageIndex(t *testing.T) {
+	debug := log.New(ioutil.Discard, "", 0)
+	for i, tc := range pageIndexTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "blogsync")
+			if err != nil {
+				t.Fatalf("error creating temp dir: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			for name, meta := range tc.files {
+				err = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+				if err != nil {
+					t.Fatalf("error creating directory for %s: %v", name, err)
+				}
+				err = ioutil.WriteFile(filepath.Join(dir, name), []byte("+++\n"+meta+"\n+++\nBody\n"), 0644)
+				if err != nil {
+					t.Fatalf("error writing %s: %v", name, err)
+				}
+			}
+
+			siteConfig := Config{Collection: "blog", Content: dir, location: time.UTC}
+			siteConfig.Slugs.Disambiguate = tc.disambiguate
+			siteConfig.Permalinks = tc.permalinks
+			opts := newPublishOpts(siteConfig)
+			opts.drafts, opts.future, opts.expired = tc.drafts, tc.future, tc.expired
+			opts.collections = tc.collections
+			sites, err := languageSites(opts, siteConfig)
+			if err != nil {
+				t.Fatalf("error loading sites: %v", err)
+			}
+			idx, err := buildPageIndex(sites, debug)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(strings.Replace(err.Error(), dir+string(filepath.Separator), "", -1), tc.err) {
+					t.Fatalf("wrong error: want=%q, got=%v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error building index: %v", err)
+			}
+			slugs := make(map[string]string)
+			for pagePath, page := range idx {
+				if page.published {
+					slugs[filepath.Base(pagePath)] = page.slug
+				}
+			}
+			if !reflect.DeepEqual(slugs, tc.slugs) {
+				t.Errorf("wrong slugs: want=%v, got=%v", tc.slugs, slugs)
+			}
+		})
+	}
+}
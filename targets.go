@@ -0,0 +1,138 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/writeas/go-writeas/v2"
+)
+
+// Target is a write.as compatible server that the site is published to.
+type Target struct {
+	// Name is used to select the target with the --target flag.
+	Name string `toml:"Name"`
+
+	// URL is the base API URL of the server, for example
+	// "https://write.as/api".
+	URL string `toml:"URL"`
+
+	// TokenEnv is the name of an environment variable containing the access
+	// token, and TokenFile is the path to a file in the same format as the
+	// writeas-cli user.json file.
+	// If both are set, TokenEnv is tried first.
+	// If neither are set, the current account for the URL in the credentials
+	// file created by the "token login" command is used.
+	TokenEnv  string `toml:"TokenEnv"`
+	TokenFile string `toml:"TokenFile"`
+
+	// TorPort is the port of a local Tor SOCKS proxy to connect through.
+	// If it is not set, the --orport flag is used.
+	TorPort int `toml:"TorPort"`
+
+	// Collections maps collection aliases used in the site to the alias of the
+	// collection on this target.
+	// Collections that are not listed keep the same alias.
+	Collections map[string]string `toml:"Collections"`
+}
+
+// token returns the access token for the target.
+func (t Target) token(debug *log.Logger) (string, error) {
+	if t.TokenEnv != "" {
+		if tok := os.Getenv(t.TokenEnv); tok != "" {
+			return tok, nil
+		}
+		debug.Printf("no token found in $%s for target %s", t.TokenEnv, t.Name)
+	}
+	if t.TokenFile != "" {
+		_, tok, err := readUserFile(expandHome(t.TokenFile, debug))
+		if err != nil {
+			return "", fmt.Errorf("error reading token for target %s: %w", t.Name, err)
+		}
+		if tok != "" {
+			return tok, nil
+		}
+		debug.Printf("no token found in %s for target %s", t.TokenFile, t.Name)
+	}
+	if t.TokenEnv == "" && t.TokenFile == "" {
+		creds, err := loadCredentials(credentialsPath(debug))
+		if err != nil {
+			return "", fmt.Errorf("error reading credentials file for target %s: %w", t.Name, err)
+		}
+		if _, tok := creds.current(t.URL); tok != "" {
+			return tok, nil
+		}
+	}
+	return "", fmt.Errorf("no token found for target %s, set TokenEnv or TokenFile or log in with the token command", t.Name)
+}
+
+// config returns the config used to create write.as clients for the target.
+func (t Target) config(torPort int, debug *log.Logger) (writeas.Config, error) {
+	if t.URL == "" {
+		return writeas.Config{}, fmt.Errorf("no URL set for target %s", t.Name)
+	}
+	tok, err := t.token(debug)
+	if err != nil {
+		return writeas.Config{}, err
+	}
+	if t.TorPort != 0 {
+		torPort = t.TorPort
+	}
+	return writeas.Config{
+		URL:     t.URL,
+		Token:   tok,
+		TorPort: torPort,
+	}, nil
+}
+
+// selectTargets returns the targets with the given comma separated names, or
+// all targets if names is empty.
+func selectTargets(targets []Target, names string) ([]Target, error) {
+	if names == "" {
+		return targets, nil
+	}
+
+	var selected []Target
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		var found bool
+		for _, t := range targets {
+			if t.Name == name {
+				selected = append(selected, t)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no target named %q in the config", name)
+		}
+	}
+	return selected, nil
+}
+
+// remoteCollection returns the alias of the collection on the target being
+// published to.
+func (opts publishOptions) remoteCollection(alias string) string {
+	if remote, ok := opts.collections[alias]; ok {
+		return remote
+	}
+	return alias
+}
+
+// expandHome replaces a leading "~" in path with the users home directory.
+func expandHome(path string, debug *log.Logger) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		debug.Printf("error fetching home directory: %v", err)
+		return path
+	}
+	return home + path[1:]
+}
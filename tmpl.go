@@ -21,6 +21,8 @@ func tmplArticle() *cli.Command {
 		Config: Config{
 			Params: make(map[string]interface{}),
 		},
+		Resources:    []string{},
+		Translations: []translation{{}},
 	}
 	var encodedTmplData strings.Builder
 	e := toml.NewEncoder(&encodedTmplData)
@@ -60,7 +62,11 @@ The body field contains the markdown from the page being published, that is,
 everything after the frontmatter.  The Meta table contains the fields from the
 TOML frontmatter.  The Config field contains values loaded from the site config
 file.  If you want to add arbitrary values to the config file they must be in
-the Params section.
+the Params section.  If the page is the index of a Hugo page bundle, the
+Resources field contains the paths of the other files in the bundle relative to
+the bundle directory.  On multilingual sites the Translations field contains the
+other languages that the page has been translated into, which are found using
+the translationKey from the frontmatter or the path of the page.
 
 If no template is specified when publishing, the body is published as-is using
 the template:
@@ -9,9 +9,11 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"syscall"
+	"text/tabwriter"
 
 	"github.com/writeas/go-writeas/v2"
 	"golang.org/x/crypto/ssh/terminal"
@@ -29,18 +31,43 @@ func cfgFile(debug *log.Logger) string {
 	return filepath.Join(home, ".writeas/user.json")
 }
 
-// loadUser returns a username and  access token by reading
-// ~/.writeas/user.json, or by checking the WA_TOKEN and WA_USER environment
-// variables (in that order).
-func loadUser(debug *log.Logger) (username, token string) {
+// loadUser returns a username and access token by reading the current account
+// for apiBase from the blogsync credentials file, then ~/.writeas/user.json, or
+// by checking the WA_TOKEN and WA_USER environment variables (in that order).
+func loadUser(apiBase string, debug *log.Logger) (username, token string) {
 	tokenEnv := os.Getenv(envToken)
 	userEnv := os.Getenv(envUser)
 
-	f, err := os.Open(cfgFile(debug))
+	creds, err := loadCredentials(credentialsPath(debug))
 	if err != nil {
-		debug.Printf("error opening %s, trying $%s instead: %v", userConfig, envToken, err)
+		debug.Printf("error reading credentials file, trying %s instead: %v", userConfig, err)
+	} else if username, token = creds.current(apiBase); token != "" {
+		return username, token
+	}
+
+	username, token, err = readUserFile(cfgFile(debug))
+	if err != nil {
+		debug.Printf("error reading %s, trying $%s instead: %v", userConfig, envToken, err)
+		return userEnv, tokenEnv
+	}
+
+	if token == "" {
+		debug.Printf("no token found in %s, trying $%s instead", userConfig, envToken)
 		return userEnv, tokenEnv
 	}
+
+	return username, token
+}
+
+// readUserFile decodes a username and access token from a file in the same
+// format as the writeas-cli user.json file.
+func readUserFile(fname string) (username, token string, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
 	d := json.NewDecoder(f)
 	var user = struct {
 		Token string `json:"access_token"`
@@ -50,24 +77,15 @@ func loadUser(debug *log.Logger) (username, token string) {
 	}{}
 	err = d.Decode(&user)
 	if err != nil {
-		debug.Printf("error decoding %s, trying $%s instead: %v", userConfig, envToken, err)
-		return userEnv, tokenEnv
+		return "", "", err
 	}
-
-	if user.Token == "" {
-		debug.Printf("no token found in %s, trying $%s instead", userConfig, envToken)
-		return userEnv, tokenEnv
-	}
-
-	return user.User.Username, user.Token
+	return user.User.Username, user.Token, nil
 }
 
-func tokenCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Command {
-	const (
-		envPass = "WA_PASS"
-	)
+const envPass = "WA_PASS"
 
-	username, _ := loadUser(debug)
+func tokenCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Command {
+	username, _ := loadUser(apiBase, debug)
 	revoke := false
 
 	flags := flag.NewFlagSet("token", flag.ContinueOnError)
@@ -75,12 +93,25 @@ func tokenCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Comma
 	flags.BoolVar(&revoke, "revoke", revoke, "Revoke any listed tokens instead of generating a new one")
 
 	return &cli.Command{
-		Usage: `token [--revoke tokens...]`,
+		Usage: `token [--revoke tokens...] [command]`,
 		Flags: flags,
-		Description: `Generate or revoke an access token.
+		Description: fmt.Sprintf(`Generate or revoke an access token.
+
+Reads the users password from $%s, or prompts for a password if the
+environment variable is not set.
 
-Reads the users password from $WA_PASS, or prompts for a password if the
-environment variable is not set.`,
+To store tokens for multiple accounts, use the login, logout, list, and use
+subcommands.
+Tokens are stored in %s in the user config directory (normally
+$XDG_CONFIG_HOME on Linux) with permissions that only allow the current user to
+read them, and the current account for the API URL is used by other commands.`, envPass, filepath.FromSlash(credentialsFile)),
+		Commands: []*cli.Command{
+			tokenListCmd(apiBase, logger, debug),
+			tokenLoginCmd(apiBase, torPort, logger, debug),
+			tokenLogoutCmd(apiBase, torPort, logger, debug),
+			tokenUseCmd(apiBase, logger, debug),
+			tokenWhoamiCmd(apiBase, torPort, logger, debug),
+		},
 		Run: func(cmd *cli.Command, args ...string) error {
 			if revoke {
 				var err error
@@ -104,28 +135,196 @@ environment variable is not set.`,
 				return fmt.Errorf("wrong number of arguments")
 			}
 
-			pass := os.Getenv(envPass)
-			switch {
-			case len(pass) == 0:
-				fmt.Printf("Enter password for write.as user %s: ", username)
-				passBytes, err := terminal.ReadPassword(syscall.Stdin)
-				if err != nil {
-					return fmt.Errorf("error prompting for password, set $%s or fix TTY: %v", envPass, err)
-				}
-				fmt.Println()
-				pass = string(passBytes)
-			case len(username) == 0:
-				return fmt.Errorf("A writeas-cli config file must be present or $" + envUser + " or --user must be specified to generate tokens")
+			auth, err := logIn(apiBase, torPort, username)
+			if err != nil {
+				return err
 			}
 
-			c := writeas.NewClient()
-			auth, err := c.LogIn(username, pass)
+			fmt.Println(auth.AccessToken)
+			return nil
+		},
+	}
+}
+
+func tokenLoginCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Command {
+	username := os.Getenv(envUser)
+	flags := flag.NewFlagSet("login", flag.ContinueOnError)
+	flags.StringVar(&username, "user", username, "The username to login as, overrides $"+envUser)
+
+	return &cli.Command{
+		Usage: "login [--user username]",
+		Flags: flags,
+		Description: `Log in and store the token in the credentials file.
+
+The account becomes the current account for the API URL.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			fname := credentialsPath(debug)
+			creds, err := loadCredentials(fname)
+			if err != nil {
+				return fmt.Errorf("error reading credentials file: %w", err)
+			}
+
+			auth, err := logIn(apiBase, torPort, username)
 			if err != nil {
 				return err
 			}
+			if auth.User != nil && auth.User.Username != "" {
+				username = auth.User.Username
+			}
+			creds.set(apiBase, username, auth.AccessToken)
+			err = creds.save(fname)
+			if err != nil {
+				return fmt.Errorf("error saving credentials: %w", err)
+			}
+			logger.Printf("logged in to %s as %s", apiBase, username)
+			return nil
+		},
+	}
+}
 
-			fmt.Println(auth.AccessToken)
+func tokenLogoutCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Command {
+	return &cli.Command{
+		Usage: "logout [username]",
+		Description: `Revoke a stored token and remove it from the credentials file.
+
+If no username is given, the current account for the API URL is logged out.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			fname := credentialsPath(debug)
+			creds, err := loadCredentials(fname)
+			if err != nil {
+				return fmt.Errorf("error reading credentials file: %w", err)
+			}
+
+			var username string
+			switch len(args) {
+			case 0:
+				username, _ = creds.current(apiBase)
+				if username == "" {
+					return fmt.Errorf("not logged in to %s", apiBase)
+				}
+			case 1:
+				username = args[0]
+			default:
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+
+			tok, ok := creds.remove(apiBase, username)
+			if !ok {
+				return fmt.Errorf("no token stored for %s on %s", username, apiBase)
+			}
+			c := writeas.NewClientWith(writeas.Config{
+				URL:     apiBase,
+				Token:   tok,
+				TorPort: torPort,
+			})
+			err = c.LogOut()
+			if err != nil {
+				// Remove the token anyways, if it's already been revoked on the server
+				// we don't want to keep it around.
+				logger.Printf("error revoking token for %s: %v", username, err)
+			}
+			err = creds.save(fname)
+			if err != nil {
+				return fmt.Errorf("error saving credentials: %w", err)
+			}
+			logger.Printf("logged out %s from %s", username, apiBase)
+			return nil
+		},
+	}
+}
+
+func tokenListCmd(apiBase string, logger, debug *log.Logger) *cli.Command {
+	return &cli.Command{
+		Usage:       "list",
+		Description: `List accounts in the credentials file, the current account for each API URL is marked with "*".`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			creds, err := loadCredentials(credentialsPath(debug))
+			if err != nil {
+				return fmt.Errorf("error reading credentials file: %w", err)
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			for _, acct := range creds.accounts() {
+				current := " "
+				if acct.Current {
+					current = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", current, acct.URL, acct.Username)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func tokenUseCmd(apiBase string, logger, debug *log.Logger) *cli.Command {
+	return &cli.Command{
+		Usage:       "use username",
+		Description: `Make a stored account the current account for the API URL.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			if len(args) != 1 {
+				cmd.Help()
+				return fmt.Errorf("wrong number of arguments")
+			}
+			fname := credentialsPath(debug)
+			creds, err := loadCredentials(fname)
+			if err != nil {
+				return fmt.Errorf("error reading credentials file: %w", err)
+			}
+			tok, ok := creds.Tokens[credentialsKey(apiBase)][args[0]]
+			if !ok {
+				return fmt.Errorf("no token stored for %s on %s, try logging in first", args[0], apiBase)
+			}
+			creds.set(apiBase, args[0], tok)
+			return creds.save(fname)
+		},
+	}
+}
+
+func tokenWhoamiCmd(apiBase string, torPort int, logger, debug *log.Logger) *cli.Command {
+	return &cli.Command{
+		Usage:       "whoami",
+		Description: `Check that the current token is valid and print the user it belongs to.`,
+		Run: func(cmd *cli.Command, args ...string) error {
+			_, tok := loadUser(apiBase, debug)
+			if tok == "" {
+				return fmt.Errorf("no token found for %s", apiBase)
+			}
+			api := newAPIClient(writeas.Config{
+				URL:     apiBase,
+				Token:   tok,
+				TorPort: torPort,
+			}, debug)
+			var user writeas.User
+			err := api.do(http.MethodGet, "/me", nil, &user)
+			if err != nil {
+				return fmt.Errorf("error checking token: %w", err)
+			}
+			fmt.Println(user.Username)
 			return nil
 		},
 	}
 }
+
+// logIn prompts for the password of username if it is not set in the
+// environment and logs in to the server at apiBase.
+func logIn(apiBase string, torPort int, username string) (*writeas.AuthUser, error) {
+	if len(username) == 0 {
+		return nil, fmt.Errorf("A writeas-cli config file must be present or $" + envUser + " or --user must be specified to generate tokens")
+	}
+	pass := os.Getenv(envPass)
+	if len(pass) == 0 {
+		fmt.Printf("Enter password for %s user %s: ", apiBase, username)
+		passBytes, err := terminal.ReadPassword(syscall.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("error prompting for password, set $%s or fix TTY: %v", envPass, err)
+		}
+		fmt.Println()
+		pass = string(passBytes)
+	}
+
+	c := writeas.NewClientWith(writeas.Config{
+		URL:     apiBase,
+		TorPort: torPort,
+	})
+	return c.LogIn(username, pass)
+}
@@ -5,42 +5,254 @@
 package main
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"mellium.im/blogsync/internal/blog"
 )
 
-func newWatcher(content string, debug *log.Logger) (watcher *fsnotify.Watcher, err error) {
-	watcher, err = fsnotify.NewWatcher()
+// treeWatcher watches directory trees for changes and keeps track of the
+// directories that are being watched so that they can be removed again.
+type treeWatcher struct {
+	*fsnotify.Watcher
+	dirs  map[string]bool
+	debug *log.Logger
+}
+
+// newWatcher watches the content directory of each language site and the
+// files or directories in sources for changes.
+// Content directories that are shared by multiple languages are only watched
+// once.
+func newWatcher(sites []langSite, sources []string, debug *log.Logger) (w *treeWatcher, err error) {
+	fsWatcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return nil, err
 	}
+	w = &treeWatcher{
+		Watcher: fsWatcher,
+		dirs:    make(map[string]bool),
+		debug:   debug,
+	}
 	// Handle errors by cleaning up so that the caller can follow Go idioms of
 	// checking errors before handling the value (eg. in case an error happens
 	// while adding files to the already existing watcher).
 	defer func() {
 		if err != nil {
-			if err := watcher.Close(); err != nil {
-				debug.Printf("error closing unused %s watcher: %v", content, err)
+			if err := w.Close(); err != nil {
+				debug.Printf("error closing unused watcher: %v", err)
 			}
 		}
 	}()
 
-	err = filepath.Walk(content, func(path string, info os.FileInfo, err error) error {
+	for _, site := range sites {
+		content := site.opts.content
+		if w.watching(content) {
+			continue
+		}
+		_, err = w.addTree(content, site.opts.ignore)
 		if err != nil {
-			debug.Printf("error watching file %s, changes will not trigger a rebuilt: %v", path, err)
+			return w, fmt.Errorf("error watching %s for changes: %w", content, err)
+		}
+	}
+
+	// Sources are watched by watching the directory that contains them so that
+	// we still see changes if an editor replaces the file.
+	// If the directory does not exist yet, its closest existing parent is
+	// watched instead so that we see it being created.
+	for _, source := range sources {
+		dir := source
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			dir = filepath.Dir(source)
+		}
+		for {
+			if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		if w.watching(dir) {
+			continue
+		}
+		err = w.Add(dir)
+		if err != nil {
+			return w, fmt.Errorf("error watching %s for changes: %w", source, err)
+		}
+		w.dirs[filepath.Clean(dir)] = true
+	}
+
+	return w, nil
+}
+
+// watching reports whether the directory dir is being watched.
+func (w *treeWatcher) watching(dir string) bool {
+	return w.dirs[filepath.Clean(dir)]
+}
+
+// addTree watches root and every directory below it that is not ignored, and
+// returns the files that were found in them.
+func (w *treeWatcher) addTree(root string, ignore *blog.Ignore) ([]string, error) {
+	var files []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			w.debug.Printf("error watching file %s, changes will not trigger a rebuilt: %v", path, err)
 			return nil
 		}
 
 		if !info.IsDir() {
-			// Watch entire directory trees for changes, not individual files.
+			// Watch entire directory trees for changes, not individual files, but
+			// report the files so that they can be published.
+			files = append(files, path)
+			return nil
+		}
+		if ignore.Match(path, true) {
+			w.debug.Printf("not watching ignored directory %s", path)
+			return filepath.SkipDir
+		}
+		if w.watching(path) {
 			return nil
 		}
 
-		return watcher.Add(path)
+		err = w.Add(path)
+		if err != nil {
+			return err
+		}
+		w.dirs[filepath.Clean(path)] = true
+		return nil
 	})
+	return files, err
+}
+
+// removeTree stops watching root and every directory below it.
+func (w *treeWatcher) removeTree(root string) {
+	root = filepath.Clean(root)
+	for dir := range w.dirs {
+		if dir != root && !strings.HasPrefix(dir, root+string(filepath.Separator)) {
+			continue
+		}
+		// If the directory was deleted the watch has already been removed, so
+		// this is expected to fail sometimes.
+		err := w.Remove(dir)
+		if err != nil {
+			w.debug.Printf("error removing watch on %s: %v", dir, err)
+		}
+		delete(w.dirs, dir)
+	}
+}
+
+// watchSources returns the config files and directories, the template file,
+// and the ignore file of each content directory that the site is built from.
+func watchSources(opts publishOptions, siteConfig Config, sites []langSite) []string {
+	sources := make([]string, 0, len(siteConfig.sources)+len(sites)+1)
+	for _, source := range siteConfig.sources {
+		sources = append(sources, filepath.Clean(source))
+	}
+	if tmplFile := strings.TrimPrefix(opts.tmpl, "@"); tmplFile != opts.tmpl {
+		sources = append(sources, filepath.Clean(tmplFile))
+	}
+	for _, site := range sites {
+		ignoreFile := filepath.Join(site.opts.content, blog.IgnoreFile)
+		if !isSource(ignoreFile, sources) {
+			sources = append(sources, ignoreFile)
+		}
+	}
+	return sources
+}
+
+// isSource reports whether name is one of sources, a file in one of the
+// source directories, or a directory containing one of the sources.
+func isSource(name string, sources []string) bool {
+	name = filepath.Clean(name)
+	dir := filepath.Dir(name)
+	for _, source := range sources {
+		if name == source || dir == source || strings.HasPrefix(source, name+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMarkdown reports whether the file at path is a Markdown file.
+func isMarkdown(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".md" || ext == ".markdown"
+}
+
+// debounceWindow is how long the preview waits after the last event for a file
+// before acting on it.
+const debounceWindow = 200 * time.Millisecond
+
+// debouncer collects paths and releases each one once no new events have been
+// added for it during the window.
+// It is not safe for concurrent use.
+type debouncer struct {
+	window  time.Duration
+	pending map[string]time.Time
+	timer   *time.Timer
+}
+
+func newDebouncer(window time.Duration) *debouncer {
+	timer := time.NewTimer(window)
+	timer.Stop()
+	return &debouncer{
+		window:  window,
+		pending: make(map[string]time.Time),
+		timer:   timer,
+	}
+}
+
+// add schedules path to be released after the window, pushing back its
+// release if it is already pending.
+func (d *debouncer) add(path string) {
+	d.pending[path] = time.Now().Add(d.window)
+	d.reset()
+}
+
+// C returns a channel that receives when paths are ready to be released.
+func (d *debouncer) C() <-chan time.Time {
+	return d.timer.C
+}
+
+// ready removes and returns the paths whose window has passed, sorted.
+func (d *debouncer) ready() []string {
+	now := time.Now()
+	var paths []string
+	for path, deadline := range d.pending {
+		if !deadline.After(now) {
+			paths = append(paths, path)
+			delete(d.pending, path)
+		}
+	}
+	sort.Strings(paths)
+	d.reset()
+	return paths
+}
+
+// reset sets the timer to fire at the earliest pending deadline.
+func (d *debouncer) reset() {
+	if !d.timer.Stop() {
+		// Drain the channel if the timer fired but nobody received the value yet.
+		select {
+		case <-d.timer.C:
+		default:
+		}
+	}
+	var next time.Time
+	for _, deadline := range d.pending {
+		if next.IsZero() || deadline.Before(next) {
+			next = deadline
+		}
+	}
+	if !next.IsZero() {
+		d.timer.Reset(time.Until(next))
+	}
+}
 
-	return watcher, err
+func (d *debouncer) stop() {
+	d.timer.Stop()
 }
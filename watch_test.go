@@ -0,0 +1,95 @@
+// Copyright 2019 The Blog Sync Contributors.
+// Use of this source code is governed by the BSD 2-clause
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWatchSources(t *testing.T) {
+	opts := publishOptions{tmpl: "@layouts/post.tmpl"}
+	siteConfig := Config{sources: []string{"config.toml", "config/_default/"}}
+	sites := []langSite{
+		{opts: publishOptions{content: "content/en"}},
+		{opts: publishOptions{content: "content/fr"}},
+		// Languages may share a content directory.
+		{opts: publishOptions{content: "content/fr"}},
+	}
+	sources := watchSources(opts, siteConfig, sites)
+	want := []string{
+		"config.toml",
+		filepath.Join("config", "_default"),
+		filepath.Join("layouts", "post.tmpl"),
+		filepath.Join("content", "en", ".blogsyncignore"),
+		filepath.Join("content", "fr", ".blogsyncignore"),
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("wrong sources: want=%q, got=%q", want, sources)
+	}
+}
+
+var isSourceTestCases = [...]struct {
+	name   string
+	source bool
+}{
+	0: {name: "config.toml", source: true},
+	1: {name: "./config.toml", source: true},
+	2: {name: "config/_default/params.toml", source: true},
+	3: {name: "config/_default", source: true},
+	4: {name: "config", source: true},
+	5: {name: "content/.blogsyncignore", source: true},
+	6: {name: "content/post.md", source: false},
+	7: {name: "config/production/params.toml", source: false},
+	8: {name: "hugo.toml", source: false},
+}
+
+func TestIsSource(t *testing.T) {
+	sources := []string{"config.toml", filepath.Join("config", "_default"), filepath.Join("content", ".blogsyncignore")}
+	for i, tc := range isSourceTestCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if source := isSource(filepath.FromSlash(tc.name), sources); source != tc.source {
+				t.Errorf("wrong result for %s: want=%t, got=%t", tc.name, tc.source, source)
+			}
+		})
+	}
+}
+
+func TestDebouncer(t *testing.T) {
+	const window = 50 * time.Millisecond
+	d := newDebouncer(window)
+	defer d.stop()
+
+	d.add("a")
+	time.Sleep(window / 2)
+	d.add("b")
+	// Adding a path again pushes back its release.
+	d.add("a")
+	if paths := d.ready(); len(paths) != 0 {
+		t.Errorf("expected no paths before the window passed, got %q", paths)
+	}
+
+	var released []string
+	timeout := time.After(10 * window)
+	for len(released) < 2 {
+		select {
+		case <-d.C():
+			released = append(released, d.ready()...)
+		case <-timeout:
+			t.Fatalf("timed out waiting for paths, got %q", released)
+		}
+	}
+	sort.Strings(released)
+	if want := []string{"a", "b"}; !reflect.DeepEqual(released, want) {
+		t.Errorf("wrong paths: want=%q, got=%q", want, released)
+	}
+	if paths := d.ready(); len(paths) != 0 {
+		t.Errorf("expected paths to be released once, got %q", paths)
+	}
+}